@@ -5,13 +5,14 @@ import (
 	"os"
 
 	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/spf13/cobra"
 )
 
 func balancesCmd() *cobra.Command {
 	var balancesCmd = &cobra.Command{
 		Use:   "balances",
-		Short: "Interact with balances (list...).",
+		Short: "Interact with balances (list, history...).",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			return incorrectUsageErr()
 		},
@@ -20,6 +21,7 @@ func balancesCmd() *cobra.Command {
 	}
 
 	balancesCmd.AddCommand(balancesListCmd())
+	balancesCmd.AddCommand(balancesHistoryCmd())
 
 	return balancesCmd
 }
@@ -29,7 +31,7 @@ func balancesListCmd() *cobra.Command {
 		Use:   "list",
 		Short: "Lists all balances.",
 		Run: func(cmd *cobra.Command, args []string) {
-			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			state, err := database.NewStateFromDiskReadOnly(getDataDirFromCmd(cmd))
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -56,4 +58,49 @@ func balancesListCmd() *cobra.Command {
 	addDefaultRequiredFlags(balancesListCmd)
 
 	return balancesListCmd
-}
\ No newline at end of file
+}
+
+func balancesHistoryCmd() *cobra.Command {
+	var balancesHistoryCmd = &cobra.Command{
+		Use:   "history",
+		Short: "Lists TXs sent or received by an account, newest first.",
+		Run: func(cmd *cobra.Command, args []string) {
+			account, _ := cmd.Flags().GetString(flagAccount)
+			offset, _ := cmd.Flags().GetUint64(flagOffset)
+			limit, _ := cmd.Flags().GetUint64(flagLimit)
+
+			if !common.IsHexAddress(account) {
+				fmt.Fprintf(os.Stderr, "'%s' is an invalid account address\n", account)
+				os.Exit(1)
+			}
+
+			state, err := database.NewStateFromDiskReadOnly(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			txs, total, err := state.GetAccountTXs(database.NewAccount(account), offset, limit)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Account %s TXs (%d of %d):\n", account, len(txs), total)
+			fmt.Println("__________________")
+			fmt.Println("")
+			for _, tx := range txs {
+				fmt.Println(fmt.Sprintf("#%d %s %-4s %s value: %d fee: %d", tx.BlockNumber, tx.Hash.Hex(), tx.Direction, tx.Counterparty.String(), tx.Value, tx.Fee))
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(balancesHistoryCmd)
+	balancesHistoryCmd.Flags().String(flagAccount, "", "Account address whose TXs are listed")
+	balancesHistoryCmd.MarkFlagRequired(flagAccount)
+	balancesHistoryCmd.Flags().Uint64(flagOffset, 0, "Number of newest TXs to skip")
+	balancesHistoryCmd.Flags().Uint64(flagLimit, 20, "Maximum number of TXs to list")
+
+	return balancesHistoryCmd
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/spf13/cobra"
+)
+
+func initCmd() *cobra.Command {
+	var initCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Initializes the node data dir with a custom genesis file.",
+		Run: func(cmd *cobra.Command, args []string) {
+			genesisPath, _ := cmd.Flags().GetString(flagGenesis)
+			dataDir := getDataDirFromCmd(cmd)
+
+			gen, err := initDataDir(dataDir, genesisPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Initialized chain '%s' in %s\n", gen.ChainID, dataDir)
+			fmt.Printf("\t- block reward: %d\n", gen.BlockReward)
+			fmt.Printf("\t- min TX fee: %d\n", gen.MinTxFee)
+			fmt.Printf("\t- difficulty: %d\n", gen.Difficulty)
+			fmt.Printf("\t- target block time: %ds\n", gen.TargetBlockTime)
+			fmt.Printf("\t- max block size: %d bytes\n", gen.MaxBlockSize)
+			fmt.Printf("\t- max block TXs: %d\n", gen.MaxBlockTXs)
+		},
+	}
+
+	addDefaultRequiredFlags(initCmd)
+	initCmd.Flags().String(flagGenesis, "", "Absolute path to the genesis.json file defining the chain parameters and initial balances")
+	initCmd.MarkFlagRequired(flagGenesis)
+
+	return initCmd
+}
+
+// Writes the genesis file into the data dir, an initialized data dir is never overwritten
+func initDataDir(dataDir string, genesisPath string) (database.Genesis, error) {
+	content, err := ioutil.ReadFile(fs.ExpandPath(genesisPath))
+	if err != nil {
+		return database.Genesis{}, err
+	}
+
+	gen, err := database.ParseGenesis(content)
+	if err != nil {
+		return database.Genesis{}, fmt.Errorf("invalid genesis file. %s", err.Error())
+	}
+
+	if database.IsDataDirInitialized(dataDir) {
+		return database.Genesis{}, fmt.Errorf("data dir %s is already initialized", dataDir)
+	}
+
+	err = database.InitDataDirIfNotExists(dataDir, content)
+	if err != nil {
+		return database.Genesis{}, err
+	}
+
+	return gen, nil
+}
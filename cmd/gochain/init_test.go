@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+const testGenesisJson = `
+{
+  "genesis_time": "2021-01-01T00:00:00.000000000Z",
+  "chain_id": "testnet",
+  "difficulty": 64,
+  "balances": {
+    "0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A": 5000,
+    "0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57": 700
+  }
+}`
+
+func TestInitDataDir(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "node")
+
+	genesisPath := writeTestFile(t, dir, "genesis.json", testGenesisJson)
+
+	gen, err := initDataDir(dataDir, genesisPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gen.ChainID != "testnet" {
+		t.Fatalf("initialized chain ID must be 'testnet', got '%s'", gen.ChainID)
+	}
+
+	assertTestChain(t, dataDir)
+
+	otherGenesisPath := writeTestFile(t, dir, "other_genesis.json", `{"chain_id": "other", "balances": {}}`)
+	if _, err := initDataDir(dataDir, otherGenesisPath); err == nil {
+		t.Fatalf("initialized data dir must not be initialized again")
+	}
+
+	assertTestChain(t, dataDir)
+}
+
+func TestInitDataDir_InvalidGenesis(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "node")
+
+	if _, err := initDataDir(dataDir, filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatalf("missing genesis file must be rejected")
+	}
+
+	genesisPath := writeTestFile(t, dir, "genesis.json", `{"chain_id": `)
+	if _, err := initDataDir(dataDir, genesisPath); err == nil {
+		t.Fatalf("malformed genesis file must be rejected")
+	}
+
+	if database.IsDataDirInitialized(dataDir) {
+		t.Fatalf("data dir must not be initialized with an invalid genesis")
+	}
+}
+
+// Verifies the data dir holds the chain of testGenesisJson
+func assertTestChain(t *testing.T, dataDir string) {
+	state, err := database.NewStateFromDiskReadOnly(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.ChainParams().ChainID != "testnet" {
+		t.Fatalf("data dir must hold the 'testnet' chain, got '%s'", state.ChainParams().ChainID)
+	}
+
+	balances := map[string]uint{
+		"0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A": 5000,
+		"0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57": 700,
+	}
+
+	if len(state.Balances) != len(balances) {
+		t.Fatalf("data dir must hold %d genesis balances, got %d", len(balances), len(state.Balances))
+	}
+
+	for account, balance := range balances {
+		if state.Balances[database.NewAccount(account)] != balance {
+			t.Fatalf("account %s must hold %d tokens, got %d", account, balance, state.Balances[database.NewAccount(account)])
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
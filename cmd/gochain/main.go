@@ -18,6 +18,22 @@ const flagPort = "port"
 const flagBootstrapAcc = "bootstrap-account"
 const flagBootstrapIp = "bootstrap-ip"
 const flagBootstrapPort = "bootstrap-port"
+const flagBootstrap = "bootstrap"
+const flagBootstrapFile = "bootstrap-file"
+const flagStorage = "storage"
+const flagGenesis = "genesis"
+const flagAccount = "account"
+const flagOffset = "offset"
+const flagLimit = "limit"
+const flagFrom = "from"
+const flagTo = "to"
+const flagValue = "value"
+const flagFee = "fee"
+const flagNonce = "nonce"
+const flagData = "data"
+const flagChainID = "chain-id"
+const flagTxFile = "tx"
+const flagNode = "node"
 
 func main() {
 	var gochainCmd = &cobra.Command{
@@ -27,8 +43,10 @@ func main() {
 	}
 
 	gochainCmd.AddCommand(versionCmd)
+	gochainCmd.AddCommand(initCmd())
 	gochainCmd.AddCommand(balancesCmd())
 	gochainCmd.AddCommand(walletCmd())
+	gochainCmd.AddCommand(txCmd())
 	gochainCmd.AddCommand(runCmd())
 
 	err := gochainCmd.Execute()
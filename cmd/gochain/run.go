@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
 
 	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
 	"github.com/ethanblumenthal/golang-blockchain/node"
 	"github.com/spf13/cobra"
 )
@@ -23,6 +28,9 @@ func runCmd() *cobra.Command {
 			bootstrapIp, _ := cmd.Flags().GetString(flagBootstrapIp)
 			bootstrapPort, _ := cmd.Flags().GetUint64(flagBootstrapPort)
 			bootstrapAcc, _ := cmd.Flags().GetString(flagBootstrapAcc)
+			bootstrapAddrs, _ := cmd.Flags().GetStringSlice(flagBootstrap)
+			bootstrapFile, _ := cmd.Flags().GetString(flagBootstrapFile)
+			storage, _ := cmd.Flags().GetString(flagStorage)
 
 			fmt.Println("Launching GoChain node and its HTTP API...")
 
@@ -34,12 +42,30 @@ func runCmd() *cobra.Command {
 				false,
 			)
 
+			bootstraps, err := parseBootstrapPeers(bootstrapAddrs, bootstrapFile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			// The default bootstrap peer is only used when set explicitly or when no other is given
+			isBootstrapSet := cmd.Flags().Changed(flagBootstrapIp) || cmd.Flags().Changed(flagBootstrapPort) || cmd.Flags().Changed(flagBootstrapAcc)
+			if isBootstrapSet || len(bootstraps) == 0 {
+				bootstraps = append([]node.PeerNode{bootstrap}, bootstraps...)
+			}
+
 			if !isSSLDisabled {
 				port = node.HttpSSLPort
 			}
 
-			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstrap)
-			err := n.Run(context.Background(), isSSLDisabled, sslEmail)
+			err = database.InitStorage(getDataDirFromCmd(cmd), storage)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstraps...)
+			err = n.Run(context.Background(), isSSLDisabled, sslEmail)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -56,6 +82,49 @@ func runCmd() *cobra.Command {
 	runCmd.Flags().String(flagBootstrapIp, node.DefaultBootstrapIp, "default GoChain bootstrap's server to interconnect peers")
 	runCmd.Flags().Uint64(flagBootstrapPort, node.HttpSSLPort, "default GoChain bootstrap's server port to interconnect peers")
 	runCmd.Flags().String(flagBootstrapAcc, node.DefaultBootstrapAcc, "default GoChain bootstrap's Genesis account with 1M tokens")
+	runCmd.Flags().StringSlice(flagBootstrap, nil, "additional bootstrap peers as a comma separated list of 'ip:port' addresses")
+	runCmd.Flags().String(flagBootstrapFile, "", "Absolute path to a JSON file listing additional bootstrap peers, e.g. [{\"ip\": \"127.0.0.1\", \"port\": 8081, \"account\": \"0x...\"}]")
+	runCmd.Flags().String(flagStorage, "", "your node's database storage, 'file' or 'leveldb' (defaults to the data dir's current storage, 'file' for a new data dir)")
 
 	return runCmd
+}
+
+// Bootstrap peers given as 'ip:port' addresses and in the bootstrap file
+func parseBootstrapPeers(addrs []string, filePath string) ([]node.PeerNode, error) {
+	bootstraps := make([]node.PeerNode, 0)
+
+	for _, addr := range addrs {
+		ip, portRaw, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer '%s'. %s", addr, err)
+		}
+
+		port, err := strconv.ParseUint(portRaw, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer '%s' port. %s", addr, err)
+		}
+
+		bootstraps = append(bootstraps, node.NewPeerNode(ip, port, true, database.NewAccount(node.DefaultMiner), false))
+	}
+
+	if filePath == "" {
+		return bootstraps, nil
+	}
+
+	content, err := ioutil.ReadFile(fs.ExpandPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var filePeers []node.PeerNode
+	err = json.Unmarshal(content, &filePeers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap file. %s", err)
+	}
+
+	for _, peer := range filePeers {
+		bootstraps = append(bootstraps, node.NewPeerNode(peer.IP, peer.Port, true, peer.Account, false))
+	}
+
+	return bootstraps, nil
 }
\ No newline at end of file
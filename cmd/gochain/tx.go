@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/node"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+// Chain ID of the default genesis
+const defaultChainID = "gochain"
+
+const defaultNodeUrl = "http://127.0.0.1:8080"
+
+func txCmd() *cobra.Command {
+	var txCmd = &cobra.Command{
+		Use:   "tx",
+		Short: "Builds, signs and sends TXs (build, sign, send, cancel...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	txCmd.AddCommand(txBuildCmd())
+	txCmd.AddCommand(txSignCmd())
+	txCmd.AddCommand(txSendCmd())
+	txCmd.AddCommand(txCancelCmd())
+
+	return txCmd
+}
+
+func txBuildCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "build",
+		Short: "Prints an unsigned TX, no node or keystore is needed.",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString(flagFrom)
+			to, _ := cmd.Flags().GetString(flagTo)
+			value, _ := cmd.Flags().GetUint(flagValue)
+			fee, _ := cmd.Flags().GetUint(flagFee)
+			nonce, _ := cmd.Flags().GetUint(flagNonce)
+			data, _ := cmd.Flags().GetString(flagData)
+			chainID, _ := cmd.Flags().GetString(flagChainID)
+
+			tx, err := buildTx(from, to, value, fee, nonce, data, chainID)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printJson(tx)
+		},
+	}
+
+	cmd.Flags().String(flagFrom, "", "Sender account address")
+	cmd.MarkFlagRequired(flagFrom)
+	cmd.Flags().String(flagTo, "", "Recipient account address")
+	cmd.MarkFlagRequired(flagTo)
+	cmd.Flags().Uint(flagValue, 0, "Amount of tokens to send")
+	cmd.MarkFlagRequired(flagValue)
+	cmd.Flags().Uint(flagFee, database.DefaultMinTxFee, "Fee paid to the miner, a higher fee gets the TX mined sooner")
+	cmd.Flags().Uint(flagNonce, 0, "Sender's next nonce, one more than the nonce of the sender's latest TX")
+	cmd.MarkFlagRequired(flagNonce)
+	cmd.Flags().String(flagData, "", "Arbitrary TX data")
+	cmd.Flags().String(flagChainID, defaultChainID, "Chain ID from the genesis of the network the TX is meant for")
+
+	return cmd
+}
+
+func txSignCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "sign",
+		Short: "Signs a built TX with the sender's account from the local keystore, offline.",
+		Run: func(cmd *cobra.Command, args []string) {
+			var tx database.Tx
+			err := readJsonFile(getTxFileFromCmd(cmd), &tx)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			password := getPassPhrase("Please enter a password to decrypt the sender's wallet:", false)
+
+			signedTx, err := wallet.SignTxWithKeystoreAccount(tx, tx.From, password, wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printJson(signedTx)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	addTxFileFlag(cmd, "Path to the TX printed by 'gochain tx build', '-' reads it from stdin")
+
+	return cmd
+}
+
+func txSendCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "send",
+		Short: "Sends a signed TX to a node.",
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeUrl, _ := cmd.Flags().GetString(flagNode)
+
+			txHash, err := sendTxFile(nodeUrl, getTxFileFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("TX sent: %s\n", txHash.Hex())
+		},
+	}
+
+	addTxFileFlag(cmd, "Path to the TX printed by 'gochain tx sign', '-' reads it from stdin")
+	cmd.Flags().String(flagNode, defaultNodeUrl, "URL of the node's HTTP API")
+
+	return cmd
+}
+
+func txCancelCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "cancel",
+		Short: "Replaces a pending TX with a zero value self transfer paying a higher fee.",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString(flagFrom)
+			nonce, _ := cmd.Flags().GetUint(flagNonce)
+			fee, _ := cmd.Flags().GetUint(flagFee)
+			chainID, _ := cmd.Flags().GetString(flagChainID)
+			nodeUrl, _ := cmd.Flags().GetString(flagNode)
+
+			tx, err := buildTx(from, from, 0, fee, nonce, "", chainID)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			password := getPassPhrase("Please enter a password to decrypt the sender's wallet:", false)
+
+			signedTx, err := wallet.SignTxWithKeystoreAccount(tx, tx.From, password, wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			txHash, err := node.SendRawTx(nodeUrl, signedTx)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Cancelling TX sent: %s\n", txHash.Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagFrom, "", "Sender account address of the pending TX")
+	cmd.MarkFlagRequired(flagFrom)
+	cmd.Flags().Uint(flagNonce, 0, "Nonce of the pending TX")
+	cmd.MarkFlagRequired(flagNonce)
+	cmd.Flags().Uint(flagFee, 0, "Fee higher than the pending TX's fee by at least 10% and the min TX fee")
+	cmd.MarkFlagRequired(flagFee)
+	cmd.Flags().String(flagChainID, defaultChainID, "Chain ID from the genesis of the network the TX is meant for")
+	cmd.Flags().String(flagNode, defaultNodeUrl, "URL of the node's HTTP API")
+
+	return cmd
+}
+
+// Builds an unsigned TX, the cancel command builds a zero value self transfer
+func buildTx(from, to string, value, fee, nonce uint, data string, chainID string) (database.Tx, error) {
+	for _, account := range []string{from, to} {
+		if !common.IsHexAddress(account) {
+			return database.Tx{}, fmt.Errorf("'%s' is an invalid account address", account)
+		}
+	}
+
+	return database.NewTx(database.NewAccount(from), database.NewAccount(to), value, nonce, data).WithChainID(chainID).WithFee(fee), nil
+}
+
+// Sends the signed TX read from the file to the node and returns its hash
+func sendTxFile(nodeUrl string, path string) (database.Hash, error) {
+	var tx database.SignedTx
+	err := readJsonFile(path, &tx)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return node.SendRawTx(nodeUrl, tx)
+}
+
+func addTxFileFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().String(flagTxFile, "", usage)
+	cmd.MarkFlagRequired(flagTxFile)
+}
+
+func getTxFileFromCmd(cmd *cobra.Command) string {
+	txFile, _ := cmd.Flags().GetString(flagTxFile)
+	return txFile
+}
+
+func readJsonFile(path string, v interface{}) error {
+	var content []byte
+	var err error
+
+	if path == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+func printJson(v interface{}) {
+	vJson, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(vJson))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/node"
+	"github.com/spf13/cobra"
+)
+
+const testFrom = "0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A"
+const testTo = "0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57"
+
+func TestTxCmd_Flags(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  *cobra.Command
+		args []string
+	}{
+		{"build without recipient", txBuildCmd(), []string{"--from", testFrom, "--value", "10", "--nonce", "1"}},
+		{"build without nonce", txBuildCmd(), []string{"--from", testFrom, "--to", testTo, "--value", "10"}},
+		{"build with a negative value", txBuildCmd(), []string{"--from", testFrom, "--to", testTo, "--value", "-10", "--nonce", "1"}},
+		{"build with a non numeric fee", txBuildCmd(), []string{"--from", testFrom, "--to", testTo, "--value", "10", "--nonce", "1", "--fee", "high"}},
+		{"sign without data dir", txSignCmd(), []string{"--tx", "tx.json"}},
+		{"sign without TX file", txSignCmd(), []string{"--datadir", t.TempDir()}},
+		{"send without TX file", txSendCmd(), []string{"--node", "http://127.0.0.1:8080"}},
+		{"cancel without fee", txCancelCmd(), []string{"--datadir", t.TempDir(), "--from", testFrom, "--nonce", "1"}},
+		{"tx without subcommand", txCmd(), []string{}},
+	}
+
+	for _, test := range tests {
+		test.cmd.SetArgs(test.args)
+		test.cmd.SetOut(ioutil.Discard)
+		test.cmd.SetErr(ioutil.Discard)
+
+		if err := test.cmd.Execute(); err == nil {
+			t.Fatalf("%s must fail", test.name)
+		}
+	}
+}
+
+func TestBuildTx(t *testing.T) {
+	if _, err := buildTx("0x22ba", testTo, 10, 60, 1, "", "testnet"); err == nil {
+		t.Fatalf("invalid sender address must be rejected")
+	}
+
+	if _, err := buildTx(testFrom, "bob", 10, 60, 1, "", "testnet"); err == nil {
+		t.Fatalf("invalid recipient address must be rejected")
+	}
+
+	tx, err := buildTx(testFrom, testTo, 10, 60, 1, "reward", "testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.From != database.NewAccount(testFrom) || tx.To != database.NewAccount(testTo) || tx.Value != 10 || tx.Fee != 60 || tx.Nonce != 1 || tx.Data != "reward" || tx.ChainID != "testnet" {
+		t.Fatalf("TX must be built from the flags, got %+v", tx)
+	}
+}
+
+func TestSendTxFile(t *testing.T) {
+	dir := t.TempDir()
+	tx := database.NewSignedTx(database.NewTx(database.NewAccount(testFrom), database.NewAccount(testTo), 10, 1, ""), []byte{1})
+
+	txHash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txJson, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sentTx database.SignedTx
+		err := json.NewDecoder(r.Body).Decode(&sentTx)
+		if err != nil || !strings.HasSuffix(r.URL.Path, "/tx/sendRaw") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		// Stands in for the node rejecting an invalid TX
+		if sentTx.Value != 10 {
+			http.Error(w, fmt.Sprintf("wrong TX. TX value %d", sentTx.Value), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(node.TxSendRawRes{Hash: txHash})
+	}))
+	defer server.Close()
+
+	if _, err := sendTxFile(server.URL, filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatalf("missing TX file must be rejected")
+	}
+
+	if _, err := sendTxFile(server.URL, writeTestFile(t, dir, "malformed.json", `{"from": `)); err == nil {
+		t.Fatalf("malformed TX file must be rejected")
+	}
+
+	sentHash, err := sendTxFile(server.URL, writeTestFile(t, dir, "tx.json", string(txJson)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sentHash != txHash {
+		t.Fatalf("sent TX hash must be returned, got %s", sentHash.Hex())
+	}
+
+	tx.Value = 20
+	rejectedJson, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sendTxFile(server.URL, writeTestFile(t, dir, "rejected.json", string(rejectedJson))); err == nil {
+		t.Fatalf("TX rejected by the node must fail")
+	}
+}
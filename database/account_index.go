@@ -0,0 +1,130 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const TxDirectionIn = "in"
+const TxDirectionOut = "out"
+const TxDirectionSelf = "self"
+
+// A canonical TX seen from the perspective of one of its accounts
+type AccountTx struct {
+	Hash         Hash           `json:"hash"`
+	Direction    string         `json:"direction"`
+	Counterparty common.Address `json:"counterparty"`
+	Value        uint           `json:"value"`
+	Fee          uint           `json:"fee"`
+	Data         string         `json:"data"`
+	Time         uint64         `json:"time"`
+	BlockHash    Hash           `json:"block_hash"`
+	BlockNumber  uint64         `json:"block_number"`
+}
+
+// Lists the canonical TXs sent or received by the account, newest first,
+// skipping the first offset TXs. Returns the page and the total number of TXs
+func (s *State) GetAccountTXs(account common.Address, offset uint64, limit uint64) ([]AccountTx, uint64, error) {
+	txHashes, err := s.store.GetAccountTXs(account)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	locations := make([]TxLocation, 0, len(txHashes))
+	for _, txHash := range txHashes {
+		location, isMined := s.GetTxLocation(txHash)
+		if isMined {
+			locations = append(locations, location)
+		}
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].BlockNumber != locations[j].BlockNumber {
+			return locations[i].BlockNumber > locations[j].BlockNumber
+		}
+
+		return locations[i].Index > locations[j].Index
+	})
+
+	total := uint64(len(locations))
+	if offset >= total {
+		return []AccountTx{}, total, nil
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	page := make([]AccountTx, 0, end-offset)
+	blocks := make(map[Hash]Block)
+
+	for _, location := range locations[offset:end] {
+		b, isLoaded := blocks[location.BlockHash]
+		if !isLoaded {
+			b, err = s.store.GetBlock(location.BlockHash)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			blocks[location.BlockHash] = b
+		}
+
+		if location.Index >= uint64(len(b.TXs)) {
+			return nil, 0, fmt.Errorf("TX index %d is out of block '%s'", location.Index, location.BlockHash.Hex())
+		}
+
+		page = append(page, newAccountTx(account, b.TXs[location.Index], location))
+	}
+
+	return page, total, nil
+}
+
+func newAccountTx(account common.Address, tx SignedTx, location TxLocation) AccountTx {
+	txHash, _ := tx.Hash()
+
+	direction := TxDirectionIn
+	counterparty := tx.From
+
+	if tx.From == account {
+		direction = TxDirectionOut
+		counterparty = tx.To
+	}
+
+	if tx.From == account && tx.To == account {
+		direction = TxDirectionSelf
+	}
+
+	return AccountTx{
+		Hash:         txHash,
+		Direction:    direction,
+		Counterparty: counterparty,
+		Value:        tx.Value,
+		Fee:          tx.Fee,
+		Data:         tx.Data,
+		Time:         tx.Time,
+		BlockHash:    location.BlockHash,
+		BlockNumber:  location.BlockNumber,
+	}
+}
+
+// Groups the TXs hashes by their sender and recipient accounts
+func accountsTXs(txs []SignedTx) (map[common.Address][]Hash, error) {
+	account2txs := make(map[common.Address][]Hash)
+
+	for _, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		account2txs[tx.From] = append(account2txs[tx.From], txHash)
+		if tx.To != tx.From {
+			account2txs[tx.To] = append(account2txs[tx.To], txHash)
+		}
+	}
+
+	return account2txs, nil
+}
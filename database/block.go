@@ -10,8 +10,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
-const BlockReward = 100
-
 type Hash [32]byte
 
 type Block struct {
@@ -20,11 +18,14 @@ type Block struct {
 }
 
 type BlockHeader struct {
-	Parent Hash           `json:"parent"`
-	Number uint64         `json:"number"`
-	Nonce  uint32         `json:"nonce"`
-	Time   uint64         `json:"time"`
-	Miner  common.Address `json:"miner"`
+	Parent     Hash           `json:"parent"`
+	Number     uint64         `json:"number"`
+	Nonce      uint32         `json:"nonce"`
+	Time       uint64         `json:"time"`
+	Miner      common.Address `json:"miner"`
+	Difficulty uint64         `json:"difficulty"`
+	TxRoot     Hash           `json:"tx_root"`
+	StateRoot  Hash           `json:"state_root"`
 }
 
 type BlockFS struct {
@@ -32,6 +33,11 @@ type BlockFS struct {
 	Value Block `json:"block"`
 }
 
+type BlockHeaderFS struct {
+	Key   Hash        `json:"hash"`
+	Value BlockHeader `json:"header"`
+}
+
 func (h Hash) MarshalText() ([]byte, error) {
 	return []byte(h.Hex()), nil
 }
@@ -50,22 +56,131 @@ func (h Hash) IsEmpty() bool {
 	return bytes.Equal(emptyHash[:], h[:])
 }
 
-func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner common.Address, txs []SignedTx) Block {
-	return Block{BlockHeader{parent, number, nonce, time, miner}, txs}
+func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner common.Address, difficulty uint64, stateRoot Hash, txs []SignedTx) (Block, error) {
+	txRoot, err := TxsMerkleRoot(txs)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return Block{BlockHeader{parent, number, nonce, time, miner, difficulty, txRoot, stateRoot}, txs}, nil
 }
 
-func (b Block) Hash() (Hash, error) {
+// Size of the block's JSON encoding, as stored and sent to peers
+func (b Block) Size() (uint64, error) {
 	blockJson, err := json.Marshal(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(len(blockJson)), nil
+}
+
+// The header commits to the TXs with its TX root, so the header's hash identifies the whole block
+// and its proof-of-work can be verified before the TXs are downloaded
+func (b Block) Hash() (Hash, error) {
+	return b.Header.Hash()
+}
+
+func (h BlockHeader) Hash() (Hash, error) {
+	headerJson, err := json.Marshal(h)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	return sha256.Sum256(blockJson), nil
+	return sha256.Sum256(headerJson), nil
+}
+
+// Verifies the headers link up to the parent one after another and meet their own difficulty.
+// HeaderVerifier checks the difficulty against the chain, the state is verified when the blocks get imported
+func VerifyHeaderChain(parent Hash, headers []BlockHeaderFS) error {
+	for _, header := range headers {
+		hash, err := header.Value.Hash()
+		if err != nil {
+			return err
+		}
+
+		if hash != header.Key {
+			return fmt.Errorf("header '%s' hash must be '%s'", header.Key.Hex(), hash.Hex())
+		}
+
+		if header.Value.Parent != parent {
+			return fmt.Errorf("header '%s' parent must be '%s' not '%s'", hash.Hex(), parent.Hex(), header.Value.Parent.Hex())
+		}
+
+		if !IsBlockHashValid(hash, header.Value.Difficulty) {
+			return fmt.Errorf("header '%s' doesn't meet its difficulty '%d'", hash.Hex(), header.Value.Difficulty)
+		}
+
+		parent = hash
+	}
+
+	return nil
+}
+
+// Verifies downloaded headers page by page: on top of VerifyHeaderChain, each header must follow
+// its parent's height and time and carry the difficulty the retarget rule expects. The retarget
+// runs over the stored blocks and the headers verified so far, whose blocks aren't downloaded yet
+type HeaderVerifier struct {
+	state   *State
+	headers map[Hash]BlockMeta
+}
+
+func (s *State) NewHeaderVerifier() *HeaderVerifier {
+	return &HeaderVerifier{s, make(map[Hash]BlockMeta)}
 }
 
-func IsBlockHashValid(hash Hash) bool {
-	return fmt.Sprintf("%x", hash[0]) == "0" &&
-		fmt.Sprintf("%x", hash[1]) == "0" &&
-		fmt.Sprintf("%x", hash[2]) == "0" &&
-		fmt.Sprintf("%x", hash[3]) != "0"
+func (v *HeaderVerifier) Verify(parent Hash, headers []BlockHeaderFS) error {
+	err := VerifyHeaderChain(parent, headers)
+	if err != nil {
+		return err
+	}
+
+	for _, header := range headers {
+		if header.Value.Parent.IsEmpty() && header.Value.Number != 0 {
+			return fmt.Errorf("header '%s' without parent must be the genesis block not block %d", header.Key.Hex(), header.Value.Number)
+		}
+
+		if !header.Value.Parent.IsEmpty() {
+			parentMeta, isKnown := v.blockMeta(header.Value.Parent)
+			if !isKnown {
+				return fmt.Errorf("header '%s' parent '%s' is unknown", header.Key.Hex(), header.Value.Parent.Hex())
+			}
+
+			if header.Value.Number != parentMeta.Number+1 {
+				return fmt.Errorf("header '%s' number must be %d not %d", header.Key.Hex(), parentMeta.Number+1, header.Value.Number)
+			}
+
+			if header.Value.Time <= parentMeta.Time {
+				return fmt.Errorf("header '%s' time must be after its parent's time '%d' not '%d'", header.Key.Hex(), parentMeta.Time, header.Value.Time)
+			}
+		}
+
+		difficulty, err := v.state.retargetDifficulty(v.blockMeta, header.Value.Parent, header.Value.Number)
+		if err != nil {
+			return err
+		}
+
+		if header.Value.Difficulty != difficulty {
+			return fmt.Errorf("header '%s' difficulty must be '%d' not '%d'", header.Key.Hex(), difficulty, header.Value.Difficulty)
+		}
+
+		v.headers[header.Key] = BlockMeta{
+			Hash:       header.Key,
+			Parent:     header.Value.Parent,
+			Number:     header.Value.Number,
+			Time:       header.Value.Time,
+			Difficulty: header.Value.Difficulty,
+		}
+	}
+
+	return nil
+}
+
+func (v *HeaderVerifier) blockMeta(hash Hash) (BlockMeta, bool) {
+	meta, isKnown := v.headers[hash]
+	if isKnown {
+		return meta, true
+	}
+
+	return v.state.store.GetBlockMeta(hash)
 }
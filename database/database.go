@@ -1,48 +1,119 @@
 package database
 
 import (
-	"bufio"
-	"encoding/json"
-	"os"
+	"fmt"
 	"reflect"
 )
 
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_RDONLY, 0600)
+// Hashes of the latest blocks a block locator lists one by one
+const blockLocatorDenseHashes = 10
+
+// Returns up to limit canonical chain blocks following the given block hash
+func (s *State) GetBlocksAfter(blockHash Hash, limit uint64) ([]Block, error) {
+	fromHeight, err := s.heightAfter(blockHash)
 	if err != nil {
 		return nil, err
 	}
 
-	blocks := make([]Block, 0)
-	shouldStartCollecting := false
+	return s.GetBlocksRange(fromHeight, s.limitHeight(fromHeight, limit))
+}
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
+// Returns up to limit canonical chain headers following the given block hash
+func (s *State) GetHeadersAfter(blockHash Hash, limit uint64) ([]BlockHeaderFS, error) {
+	fromHeight, err := s.heightAfter(blockHash)
+	if err != nil {
+		return nil, err
 	}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		// Read the database file one line at a time
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	headers := make([]BlockHeaderFS, 0)
+	for height := fromHeight; height < s.limitHeight(fromHeight, limit); height++ {
+		hash, _ := s.store.GetCanonicalHash(height)
 
-		var blockFs BlockFS
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
+		b, err := s.store.GetBlock(hash)
 		if err != nil {
 			return nil, err
 		}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
+		headers = append(headers, BlockHeaderFS{hash, b.Header})
+	}
+
+	return headers, nil
+}
+
+// Returns up to limit canonical chain headers following the first locator block on the canonical chain,
+// following the empty hash when the chains share none
+func (s *State) GetHeadersAfterLocator(locator []Hash, limit uint64) ([]BlockHeaderFS, error) {
+	for _, hash := range locator {
+		if isCanonical(s.store, hash) {
+			return s.GetHeadersAfter(hash, limit)
+		}
+	}
+
+	return s.GetHeadersAfter(Hash{}, limit)
+}
+
+// Canonical chain hashes from the latest block back to the genesis block, one by one
+// for the first blockLocatorDenseHashes then exponentially further apart. A peer on another
+// branch finds the fork point in a few hashes without us sending the whole chain
+func (s *State) BlockLocator() []Hash {
+	locator := make([]Hash, 0)
+	if !s.hasGenesisBlock {
+		return locator
+	}
+
+	step := uint64(1)
+	for height := s.latestBlock.Header.Number; ; height -= step {
+		hash, _ := s.store.GetCanonicalHash(height)
+		locator = append(locator, hash)
+
+		if height == 0 {
+			return locator
+		}
+
+		if len(locator) >= blockLocatorDenseHashes {
+			step *= 2
+		}
+
+		if step > height {
+			step = height
 		}
+	}
+}
+
+// Height of the canonical block following the given one, the empty hash precedes the genesis block
+func (s *State) heightAfter(blockHash Hash) (uint64, error) {
+	if reflect.DeepEqual(blockHash, Hash{}) {
+		return 0, nil
+	}
+
+	if !isCanonical(s.store, blockHash) {
+		return 0, fmt.Errorf("block '%s' is not part of the canonical chain", blockHash.Hex())
+	}
 
-		// Collect new blocks when block hash found
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
+	meta, _ := s.store.GetBlockMeta(blockHash)
+	return meta.Number + 1, nil
+}
+
+func (s *State) limitHeight(fromHeight uint64, limit uint64) uint64 {
+	if fromHeight+limit < s.store.Height() {
+		return fromHeight + limit
+	}
+
+	return s.store.Height()
+}
+
+// Returns the canonical chain blocks with heights in the [from, to) range
+func (s *State) GetBlocksRange(from uint64, to uint64) ([]Block, error) {
+	blocks := make([]Block, 0)
+
+	for height := from; height < to; height++ {
+		b, err := s.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
 		}
+
+		blocks = append(blocks, b)
 	}
 
 	return blocks, nil
-}
\ No newline at end of file
+}
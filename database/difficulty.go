@@ -0,0 +1,134 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Difficulty is retargeted every DifficultyRetargetInterval blocks towards the chain's target block time
+const DifficultyRetargetInterval = 10
+
+// A single retarget can't change the difficulty by more than this factor
+const maxDifficultyAdjustment = 4
+
+// Blocks timestamped further ahead of the local clock are rejected, in seconds
+const maxBlockTimeDrift = 2 * 60 * 60
+
+var maxHash = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// Difficulty is the expected number of hashes to mine a block, the hash must be below 2^256 / difficulty
+func IsBlockHashValid(hash Hash, difficulty uint64) bool {
+	if difficulty == 0 {
+		difficulty = 1
+	}
+
+	target := new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) < 0
+}
+
+// Difficulty the block following the latest block must be mined with
+func (s *State) NextDifficulty() uint64 {
+	if !s.hasGenesisBlock {
+		return s.params.Difficulty
+	}
+
+	difficulty, _ := s.expectedDifficulty(s.latestBlockHash, s.latestBlock.Header.Number+1)
+
+	return difficulty
+}
+
+// Sum of the difficulties of the latest block and all its ancestors, the fork choice rule's weight
+func (s *State) TotalDifficulty() uint64 {
+	head, _ := s.store.GetBlockMeta(s.latestBlockHash)
+
+	return head.TotalDifficulty
+}
+
+// Time the block following the latest block is mined with,
+// the local time unless the latest block is timestamped later
+func (s *State) NextBlockTime() uint64 {
+	now := uint64(time.Now().Unix())
+	if s.hasGenesisBlock && s.latestBlock.Header.Time >= now {
+		return s.latestBlock.Header.Time + 1
+	}
+
+	return now
+}
+
+// The retarget trusts the blocks time, a block must be timestamped after its parent
+// and not too far in the future so miners can't warp the difficulty down
+func validateBlockTime(b Block, s *State) error {
+	maxTime := uint64(time.Now().Unix()) + maxBlockTimeDrift
+	if b.Header.Time > maxTime {
+		return fmt.Errorf("block time '%d' is too far in the future, must be at most '%d'", b.Header.Time, maxTime)
+	}
+
+	parent, isKnown := s.store.GetBlockMeta(b.Header.Parent)
+	if isKnown && b.Header.Time <= parent.Time {
+		return fmt.Errorf("block time must be after its parent's time '%d' not '%d'", parent.Time, b.Header.Time)
+	}
+
+	return nil
+}
+
+// Computes the difficulty of a block at the given height on top of the parent.
+// Every retarget interval, the difficulty is scaled by how much faster or slower
+// than the target block time the last interval blocks of the parent's branch were mined
+func (s *State) expectedDifficulty(parentHash Hash, number uint64) (uint64, error) {
+	return s.retargetDifficulty(s.store.GetBlockMeta, parentHash, number)
+}
+
+// Applies the retarget rule over the ancestors returned by getMeta,
+// stored blocks or headers downloaded ahead of their blocks
+func (s *State) retargetDifficulty(getMeta func(hash Hash) (BlockMeta, bool), parentHash Hash, number uint64) (uint64, error) {
+	if number == 0 {
+		return s.params.Difficulty, nil
+	}
+
+	parent, isKnown := getMeta(parentHash)
+	if !isKnown {
+		return 0, fmt.Errorf("unknown parent block '%s'", parentHash.Hex())
+	}
+
+	if number%DifficultyRetargetInterval != 0 {
+		return parent.Difficulty, nil
+	}
+
+	first := parent
+	for i := 1; i < DifficultyRetargetInterval; i++ {
+		first, isKnown = getMeta(first.Parent)
+		if !isKnown {
+			return 0, fmt.Errorf("unknown ancestor block '%s'", first.Parent.Hex())
+		}
+	}
+
+	expectedTimespan := s.params.TargetBlockTime * (DifficultyRetargetInterval - 1)
+	actualTimespan := uint64(0)
+	if parent.Time > first.Time {
+		actualTimespan = parent.Time - first.Time
+	}
+
+	if actualTimespan < expectedTimespan/maxDifficultyAdjustment {
+		actualTimespan = expectedTimespan / maxDifficultyAdjustment
+	}
+
+	if actualTimespan > expectedTimespan*maxDifficultyAdjustment {
+		actualTimespan = expectedTimespan * maxDifficultyAdjustment
+	}
+
+	difficulty := new(big.Int).SetUint64(parent.Difficulty)
+	difficulty.Mul(difficulty, new(big.Int).SetUint64(expectedTimespan))
+	difficulty.Div(difficulty, new(big.Int).SetUint64(actualTimespan))
+
+	if !difficulty.IsUint64() {
+		return ^uint64(0), nil
+	}
+
+	if difficulty.Uint64() == 0 {
+		return 1, nil
+	}
+
+	return difficulty.Uint64(), nil
+}
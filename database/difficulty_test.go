@@ -0,0 +1,76 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestState_DifficultyRetarget(t *testing.T) {
+	miner := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+
+	state, dataDir := newTestStateWithParams(t, StorageFile, ChainParams{Difficulty: testDifficulty, TargetBlockTime: 8})
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	blockTime := uint64(1)
+	mineInterval := func(spacing uint64) {
+		for i := 0; i < DifficultyRetargetInterval; i++ {
+			if state.hasGenesisBlock {
+				blockTime += spacing
+			}
+
+			addTestBlock(t, state, mineTestBlock(t, state, miner, blockTime))
+		}
+	}
+
+	mineInterval(8)
+	if state.NextDifficulty() != testDifficulty {
+		t.Fatalf("blocks mined on target must keep the difficulty, got %d", state.NextDifficulty())
+	}
+
+	mineInterval(4)
+	if state.NextDifficulty() != 2*testDifficulty {
+		t.Fatalf("blocks mined twice as fast must double the difficulty, got %d", state.NextDifficulty())
+	}
+
+	mineInterval(1000)
+	if state.NextDifficulty() != 2*testDifficulty/maxDifficultyAdjustment {
+		t.Fatalf("difficulty must drop at most %d times at once, got %d", maxDifficultyAdjustment, state.NextDifficulty())
+	}
+
+	mineInterval(1)
+	if state.NextDifficulty() != 2*testDifficulty {
+		t.Fatalf("difficulty must rise at most %d times at once, got %d", maxDifficultyAdjustment, state.NextDifficulty())
+	}
+}
+
+func TestState_BlockTime(t *testing.T) {
+	miner := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 10))
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, 10)); err == nil {
+		t.Fatalf("block timestamped at its parent's time must be rejected")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, 9)); err == nil {
+		t.Fatalf("block timestamped before its parent must be rejected")
+	}
+
+	future := uint64(time.Now().Unix()) + 2*maxBlockTimeDrift
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, future)); err == nil {
+		t.Fatalf("block timestamped too far in the future must be rejected")
+	}
+
+	ahead := uint64(time.Now().Unix()) + maxBlockTimeDrift/2
+	addTestBlock(t, state, mineTestBlock(t, state, miner, ahead))
+
+	if state.NextBlockTime() != ahead+1 {
+		t.Fatalf("next block must be timestamped after the latest block, got %d", state.NextBlockTime())
+	}
+}
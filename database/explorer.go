@@ -0,0 +1,152 @@
+package database
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Mining activity of an account over the canonical chain
+type MinerStats struct {
+	Miner           common.Address `json:"miner"`
+	Blocks          uint64         `json:"blocks"`
+	TXs             uint64         `json:"txs"`
+	Rewards         uint           `json:"rewards"`
+	LastBlockNumber uint64         `json:"last_block_number"`
+}
+
+// Miners stats of the canonical chain up to the head block, extended with
+// the blocks imported since and recomputed when the head was reorganized away
+type minerStatsCache struct {
+	mu          sync.Mutex
+	head        Hash
+	number      uint64
+	miner2stats map[common.Address]MinerStats
+}
+
+type ChainSummary struct {
+	ChainID         string `json:"chain_id"`
+	Height          uint64 `json:"height"`
+	LatestBlockHash Hash   `json:"latest_block_hash"`
+	TotalSupply     uint   `json:"total_supply"`
+	// Seconds between blocks, averaged over the whole canonical chain
+	AverageBlockTime float64 `json:"average_block_time"`
+	Difficulty       uint64  `json:"difficulty"`
+	TotalDifficulty  uint64  `json:"total_difficulty"`
+}
+
+// Number of canonical blocks mined on top of the block, including the block itself
+func (s *State) Confirmations(blockHash Hash) uint64 {
+	meta, isKnown := s.store.GetBlockMeta(blockHash)
+	if !isKnown || !isCanonical(s.store, blockHash) || meta.Number > s.latestBlock.Header.Number {
+		return 0
+	}
+
+	return s.latestBlock.Header.Number - meta.Number + 1
+}
+
+// Returns up to count canonical blocks, newest first
+func (s *State) GetLatestBlocks(count uint64) ([]Block, error) {
+	height := s.store.Height()
+	if count > height {
+		count = height
+	}
+
+	blocks, err := s.GetBlocksRange(height-count, height)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks, nil
+}
+
+// Aggregates the canonical blocks by miner, the most active miners first
+func (s *State) MinerStats() ([]MinerStats, error) {
+	cache := s.minerStats
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	fromHeight := uint64(0)
+	if !cache.head.IsEmpty() && isCanonical(s.store, cache.head) {
+		fromHeight = cache.number + 1
+	} else {
+		cache.head = Hash{}
+		cache.miner2stats = make(map[common.Address]MinerStats)
+	}
+
+	err := s.store.Iterate(fromHeight, func(hash Hash, b Block) error {
+		stats, ok := cache.miner2stats[b.Header.Miner]
+		if !ok {
+			stats = MinerStats{Miner: b.Header.Miner}
+		}
+
+		stats.Blocks++
+		stats.TXs += uint64(len(b.TXs))
+		stats.Rewards += s.params.BlockReward + totalFees(b.TXs)
+		stats.LastBlockNumber = b.Header.Number
+		cache.miner2stats[b.Header.Miner] = stats
+
+		cache.head = hash
+		cache.number = b.Header.Number
+
+		return nil
+	})
+	if err != nil {
+		// The partly applied blocks can't be told apart, start over next time
+		cache.head = Hash{}
+		return nil, err
+	}
+
+	minersStats := make([]MinerStats, 0, len(cache.miner2stats))
+	for _, stats := range cache.miner2stats {
+		minersStats = append(minersStats, stats)
+	}
+
+	sort.Slice(minersStats, func(i, j int) bool {
+		if minersStats[i].Blocks != minersStats[j].Blocks {
+			return minersStats[i].Blocks > minersStats[j].Blocks
+		}
+
+		return minersStats[i].LastBlockNumber > minersStats[j].LastBlockNumber
+	})
+
+	return minersStats, nil
+}
+
+func (s *State) ChainSummary() (ChainSummary, error) {
+	summary := ChainSummary{
+		ChainID:         s.params.ChainID,
+		Height:          s.store.Height(),
+		LatestBlockHash: s.latestBlockHash,
+		Difficulty:      s.NextDifficulty(),
+	}
+
+	for _, balance := range s.Balances {
+		summary.TotalSupply += balance
+	}
+
+	if !s.hasGenesisBlock {
+		return summary, nil
+	}
+
+	head, _ := s.store.GetBlockMeta(s.latestBlockHash)
+	summary.TotalDifficulty = head.TotalDifficulty
+
+	if head.Number > 0 {
+		genesisBlock, err := s.GetBlockByHeight(0)
+		if err != nil {
+			return ChainSummary{}, err
+		}
+
+		if head.Time > genesisBlock.Header.Time {
+			summary.AverageBlockTime = float64(head.Time-genesisBlock.Header.Time) / float64(head.Number)
+		}
+	}
+
+	return summary, nil
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func InitDataDirIfNotExists(dataDir string, genesis []byte) error {
-	if fileExist(getGenesisJsonFilePath(dataDir)) {
+	if IsDataDirInitialized(dataDir) {
 		return nil
 	}
 
@@ -26,6 +26,10 @@ func InitDataDirIfNotExists(dataDir string, genesis []byte) error {
 	return nil
 }
 
+func IsDataDirInitialized(dataDir string) bool {
+	return fileExist(getGenesisJsonFilePath(dataDir))
+}
+
 func getDatabaseDirPath(dataDir string) string {
 	return filepath.Join(dataDir, "database")
 }
@@ -38,6 +42,30 @@ func getBlocksDbFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), "block.db")
 }
 
+func getBlocksIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.idx")
+}
+
+func getHeightsIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "height.idx")
+}
+
+func getTxsIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "tx.idx")
+}
+
+func getAccountsIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "account.idx")
+}
+
+func getLevelDBDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "chain.ldb")
+}
+
+func getStateDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "state")
+}
+
 func fileExist(filePath string) bool {
 	_, err := os.Stat(filePath)
 	if err != nil && os.IsNotExist(err) {
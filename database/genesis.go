@@ -3,38 +3,99 @@ package database
 import (
 	"encoding/json"
 	"io/ioutil"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+const DefaultBlockReward = uint(100)
+const DefaultMinTxFee = uint(50)
+const DefaultDifficulty = uint64(1) << 24
+const DefaultTargetBlockTime = uint64(60)
+const DefaultMaxBlockSize = uint64(1024 * 1024)
+const DefaultMaxBlockTXs = uint64(1000)
+
 var genesisJson = `
 {
   "genesis_time": "2019-05-05T00:00:00.000000000Z",
   "chain_id": "gochain",
+  "block_reward": 100,
+  "min_tx_fee": 50,
+  "difficulty": 16777216,
+  "target_block_time": 60,
+  "max_block_size": 1048576,
+  "max_block_txs": 1000,
   "balances": {
     "0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A": 1000000
   }
 }`
 
+// Consensus parameters of the chain, zero values fall back to the defaults
+type ChainParams struct {
+	ChainID         string `json:"chain_id"`
+	BlockReward     uint   `json:"block_reward"`
+	MinTxFee        uint   `json:"min_tx_fee"`
+	Difficulty      uint64 `json:"difficulty"`
+	TargetBlockTime uint64 `json:"target_block_time"`
+	MaxBlockSize    uint64 `json:"max_block_size"`
+	MaxBlockTXs     uint64 `json:"max_block_txs"`
+}
+
 type Genesis struct {
+	Time time.Time `json:"genesis_time"`
+	ChainParams
 	Balances map[common.Address]uint `json:"balances"`
 }
 
-func loadGenesis(path string) (Genesis, error) {
-	content, err := ioutil.ReadFile(path)
+func ParseGenesis(content []byte) (Genesis, error) {
+	var parsedGenesis Genesis
+	err := json.Unmarshal(content, &parsedGenesis)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	var loadedGenesis Genesis
-	err = json.Unmarshal(content, &loadedGenesis)
+	parsedGenesis.ChainParams = parsedGenesis.ChainParams.withDefaults()
+
+	return parsedGenesis, nil
+}
+
+func loadGenesis(path string) (Genesis, error) {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	return loadedGenesis, nil
+	return ParseGenesis(content)
 }
 
 func writeGenesisToDisk(path string, genesis []byte) error {
 	return ioutil.WriteFile(path, genesis, 0644)
-}
\ No newline at end of file
+}
+
+func (p ChainParams) withDefaults() ChainParams {
+	if p.BlockReward == 0 {
+		p.BlockReward = DefaultBlockReward
+	}
+
+	if p.MinTxFee == 0 {
+		p.MinTxFee = DefaultMinTxFee
+	}
+
+	if p.Difficulty == 0 {
+		p.Difficulty = DefaultDifficulty
+	}
+
+	if p.TargetBlockTime == 0 {
+		p.TargetBlockTime = DefaultTargetBlockTime
+	}
+
+	if p.MaxBlockSize == 0 {
+		p.MaxBlockSize = DefaultMaxBlockSize
+	}
+
+	if p.MaxBlockTXs == 0 {
+		p.MaxBlockTXs = DefaultMaxBlockTXs
+	}
+
+	return p
+}
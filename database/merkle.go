@@ -0,0 +1,145 @@
+package database
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Domain separation prefixes, a leaf can't be passed off as an inner node and vice versa
+const merkleLeafPrefix = 0x00
+const merkleNodePrefix = 0x01
+
+// A sibling hash on the path from a Merkle tree leaf to its root
+type MerkleProofNode struct {
+	Hash   Hash `json:"hash"`
+	IsLeft bool `json:"is_left"`
+}
+
+// Computes the Merkle root of the block TXs hashes in their block order
+func TxsMerkleRoot(txs []SignedTx) (Hash, error) {
+	leaves, err := txsHashes(txs)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return merkleRoot(leaves), nil
+}
+
+// Builds the proof that the TX with the given hash is included in the TXs Merkle root
+func TxMerkleProof(txs []SignedTx, txHash Hash) ([]MerkleProofNode, error) {
+	leaves, err := txsHashes(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, leaf := range leaves {
+		if leaf == txHash {
+			return merkleProof(leaves, i), nil
+		}
+	}
+
+	return nil, fmt.Errorf("TX '%s' is not included in the block", txHash.Hex())
+}
+
+// Verifies a TX inclusion proof against a block header TXs Merkle root.
+// Light clients only need the block header to check a TX was mined
+func VerifyTxMerkleProof(txHash Hash, proof []MerkleProofNode, root Hash) bool {
+	return verifyMerkleProof(txHash, proof, root)
+}
+
+// An unpaired node is promoted to the next level unchanged
+func merkleRoot(leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return Hash{}
+	}
+
+	level := hashMerkleLeaves(leaves)
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+func merkleProof(leaves []Hash, index int) []MerkleProofNode {
+	proof := make([]MerkleProofNode, 0)
+	level := hashMerkleLeaves(leaves)
+
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, MerkleProofNode{level[index-1], true})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleProofNode{level[index+1], false})
+		}
+
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+
+	return proof
+}
+
+func verifyMerkleProof(leaf Hash, proof []MerkleProofNode, root Hash) bool {
+	hash := hashMerkleLeaf(leaf)
+
+	for _, node := range proof {
+		if node.IsLeft {
+			hash = hashMerklePair(node.Hash, hash)
+		} else {
+			hash = hashMerklePair(hash, node.Hash)
+		}
+	}
+
+	return hash == root
+}
+
+func nextMerkleLevel(level []Hash) []Hash {
+	next := make([]Hash, 0, (len(level)+1)/2)
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+
+		next = append(next, hashMerklePair(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+func hashMerkleLeaves(leaves []Hash) []Hash {
+	hashes := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = hashMerkleLeaf(leaf)
+	}
+
+	return hashes
+}
+
+func hashMerkleLeaf(leaf Hash) Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, leaf[:]...))
+}
+
+func hashMerklePair(left Hash, right Hash) Hash {
+	pair := make([]byte, 0, 1+2*len(left))
+	pair = append(pair, merkleNodePrefix)
+	pair = append(pair, left[:]...)
+
+	return sha256.Sum256(append(pair, right[:]...))
+}
+
+func txsHashes(txs []SignedTx) ([]Hash, error) {
+	hashes := make([]Hash, len(txs))
+
+	for i, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = txHash
+	}
+
+	return hashes, nil
+}
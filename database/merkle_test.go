@@ -0,0 +1,89 @@
+package database
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTxMerkleProof(t *testing.T) {
+	from := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+	to := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	for txsCount := 1; txsCount <= 7; txsCount++ {
+		txs := make([]SignedTx, txsCount)
+		for i := range txs {
+			txs[i] = NewSignedTx(NewTx(from, to, uint(i), uint(i+1), ""), []byte{byte(i)})
+		}
+
+		root, err := TxsMerkleRoot(txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tx := range txs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proof, err := TxMerkleProof(txs, txHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !VerifyTxMerkleProof(txHash, proof, root) {
+				t.Fatalf("proof of TX '%s' in a block of %d TXs should be valid", txHash.Hex(), txsCount)
+			}
+
+			if VerifyTxMerkleProof(Hash{}, proof, root) {
+				t.Fatalf("proof of TX '%s' should not verify a different TX", txHash.Hex())
+			}
+		}
+
+		// Without domain separation the inner node of the first two TXs
+		// would verify as a leaf with the rest of the first TX's proof
+		if txsCount >= 4 {
+			hashes, err := txsHashes(txs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proof, err := TxMerkleProof(txs, hashes[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			innerNode := hashMerklePair(hashMerkleLeaf(hashes[0]), hashMerkleLeaf(hashes[1]))
+			if VerifyTxMerkleProof(innerNode, proof[1:], root) {
+				t.Fatalf("inner node of a block of %d TXs should not verify as a TX", txsCount)
+			}
+		}
+	}
+}
+
+func TestAccountProof(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint), Account2Nonce: make(map[common.Address]uint)}
+	for i := 1; i <= 5; i++ {
+		account := common.BigToAddress(big.NewInt(int64(i)))
+		s.Balances[account] = uint(i * 100)
+		s.Account2Nonce[account] = uint(i)
+	}
+	root := s.StateRoot()
+
+	for account, balance := range s.Balances {
+		proof, err := s.AccountProof(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !VerifyAccountProof(account, balance, s.Account2Nonce[account], proof, root) {
+			t.Fatalf("proof of account '%s' should be valid", account.String())
+		}
+
+		if VerifyAccountProof(account, balance+1, s.Account2Nonce[account], proof, root) {
+			t.Fatalf("proof of account '%s' should not verify a different balance", account.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// A snapshot is taken every snapshotInterval canonical blocks
+const snapshotInterval = 100
+
+// Number of rotating snapshots kept, older ones survive reorgs of the newest
+const snapshotSlots = 3
+
+// Balances and nonces right after applying the referenced block
+type Snapshot struct {
+	BlockHash     Hash                    `json:"block_hash"`
+	BlockNumber   uint64                  `json:"block_number"`
+	Balances      map[common.Address]uint `json:"balances"`
+	Account2Nonce map[common.Address]uint `json:"account2nonce"`
+}
+
+func (s *State) takeSnapshotIfDue() error {
+	if s.readOnly || !s.hasGenesisBlock || s.latestBlock.Header.Number%snapshotInterval != 0 {
+		return nil
+	}
+
+	snapshot := Snapshot{s.latestBlockHash, s.latestBlock.Header.Number, s.Balances, s.Account2Nonce}
+
+	snapshotJson, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Persisting state snapshot at height %d\n", snapshot.BlockNumber)
+
+	return s.store.PutState(snapshotKey(snapshot.BlockNumber/snapshotInterval%snapshotSlots), snapshotJson)
+}
+
+// Finds the most recent snapshot whose block is still part of the canonical chain
+// and whose balances and nonces match the block's state root
+func loadLatestValidSnapshot(store Storage) (Snapshot, bool, error) {
+	latest := Snapshot{}
+	found := false
+
+	for slot := uint64(0); slot < snapshotSlots; slot++ {
+		snapshotJson, exists, err := store.GetState(snapshotKey(slot))
+		if err != nil {
+			return Snapshot{}, false, err
+		}
+
+		if !exists {
+			continue
+		}
+
+		var snapshot Snapshot
+		err = json.Unmarshal(snapshotJson, &snapshot)
+		if err != nil {
+			fmt.Printf("Ignoring unreadable state snapshot. %s\n", err)
+			continue
+		}
+
+		if !isCanonical(store, snapshot.BlockHash) {
+			continue
+		}
+
+		if found && snapshot.BlockNumber <= latest.BlockNumber {
+			continue
+		}
+
+		b, err := store.GetBlock(snapshot.BlockHash)
+		if err != nil {
+			return Snapshot{}, false, err
+		}
+
+		snapshotState := State{Balances: snapshot.Balances, Account2Nonce: snapshot.Account2Nonce}
+		if snapshotState.StateRoot() != b.Header.StateRoot {
+			fmt.Printf("Ignoring state snapshot at height %d not matching the block's state root\n", snapshot.BlockNumber)
+			continue
+		}
+
+		latest = snapshot
+		found = true
+	}
+
+	return latest, found, nil
+}
+
+func snapshotKey(slot uint64) string {
+	return fmt.Sprintf("snapshot%d", slot)
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestState_Snapshots(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			miner1 := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+			miner2 := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+			// Blocks are mined exactly on target so the difficulty never retargets
+			params := ChainParams{Difficulty: testDifficulty, TargetBlockTime: 1}
+			state, dataDir := newTestStateWithParams(t, storage, params)
+			defer os.RemoveAll(dataDir)
+
+			peerState, peerDataDir := newTestStateWithParams(t, storage, params)
+			defer os.RemoveAll(peerDataDir)
+			defer peerState.Close()
+
+			// Both nodes share the chain up to the fork height
+			forkHeight := uint64(2*snapshotInterval + snapshotInterval/2)
+			for number := uint64(0); number <= forkHeight; number++ {
+				b := mineTestBlock(t, state, miner1, number+1)
+				addTestBlock(t, state, b)
+				addTestBlock(t, peerState, b)
+			}
+
+			for number := forkHeight + 1; number <= 3*snapshotInterval; number++ {
+				addTestBlock(t, state, mineTestBlock(t, state, miner1, number+1))
+			}
+
+			// The snapshots of heights 0, 100, 200 and 300 rotated through the slots
+			for slot, number := range []uint64{3 * snapshotInterval, snapshotInterval, 2 * snapshotInterval} {
+				snapshot := loadTestSnapshot(t, state, uint64(slot))
+				if snapshot.BlockNumber != number {
+					t.Fatalf("snapshot slot %d must hold height %d, got %d", slot, number, snapshot.BlockNumber)
+				}
+			}
+
+			if _, exists, _ := state.store.GetState(snapshotKey(snapshotSlots)); exists {
+				t.Fatalf("only %d snapshots must be kept", snapshotSlots)
+			}
+
+			head := state.LatestBlockHash()
+			reward := state.ChainParams().BlockReward
+
+			snapshot, found, err := loadLatestValidSnapshot(state.store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !found || snapshot.BlockHash != head || snapshot.Balances[miner1] != (3*snapshotInterval+1)*reward {
+				t.Fatalf("latest snapshot must hold the state of the head, got %+v", snapshot)
+			}
+
+			state = reopenTestState(t, state, dataDir)
+			if state.LatestBlockHash() != head || state.Balances[miner1] != (3*snapshotInterval+1)*reward {
+				t.Fatalf("state must be restored from the snapshot of the head")
+			}
+
+			// The peer's longer branch abandons the block of the latest snapshot
+			for number := forkHeight + 1; number <= 3*snapshotInterval+2; number++ {
+				b := mineTestBlock(t, peerState, miner2, number+1)
+				addTestBlock(t, peerState, b)
+				addTestBlock(t, state, b)
+			}
+
+			peerHead := peerState.LatestBlockHash()
+			if state.LatestBlockHash() != peerHead {
+				t.Fatalf("the heavier branch must become the canonical chain")
+			}
+
+			snapshot, found, err = loadLatestValidSnapshot(state.store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !found || snapshot.BlockNumber != 2*snapshotInterval {
+				t.Fatalf("snapshot of an abandoned block must be ignored, got %+v", snapshot)
+			}
+
+			state = reopenTestState(t, state, dataDir)
+
+			miner2Blocks := 3*snapshotInterval + 2 - forkHeight
+			if state.LatestBlockHash() != peerHead || state.Balances[miner1] != uint(forkHeight+1)*reward || state.Balances[miner2] != uint(miner2Blocks)*reward {
+				t.Fatalf("state must be replayed from the latest canonical snapshot")
+			}
+
+			// The replay snapshotted the new branch at height 300, once corrupted
+			// it doesn't match its block's state root and the older one is used
+			snapshot = loadTestSnapshot(t, state, 0)
+			snapshot.Balances[miner1] += reward
+			snapshotJson, err := json.Marshal(snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = state.store.PutState(snapshotKey(0), snapshotJson)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot, found, err = loadLatestValidSnapshot(state.store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !found || snapshot.BlockNumber != 2*snapshotInterval {
+				t.Fatalf("corrupted snapshot must be ignored, got %+v", snapshot)
+			}
+
+			state = reopenTestState(t, state, dataDir)
+			defer state.Close()
+
+			if state.LatestBlockHash() != peerHead || state.Balances[miner1] != uint(forkHeight+1)*reward {
+				t.Fatalf("state must be replayed from the older snapshot")
+			}
+		})
+	}
+}
+
+func loadTestSnapshot(t *testing.T, s *State, slot uint64) Snapshot {
+	snapshotJson, exists, err := s.store.GetState(snapshotKey(slot))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists {
+		t.Fatalf("snapshot slot %d must exist", slot)
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(snapshotJson, &snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func reopenTestState(t *testing.T, s *State, dataDir string) *State {
+	err := s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopenedState, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reopenedState
+}
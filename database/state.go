@@ -1,25 +1,30 @@
 package database
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"os"
 	"reflect"
 	"sort"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
-const TxFee = uint(50)
-
 type State struct {
 	Balances        map[common.Address]uint
 	Account2Nonce   map[common.Address]uint
-	dbFile          *os.File
+	params          ChainParams
+	store           Storage
 	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
+
+	// Serializes block imports, the miner and the sync both import blocks.
+	// Shared with the state's copies
+	importMu *sync.Mutex
+	readOnly bool
+
+	// Shared with the state's copies
+	minerStats *minerStatsCache
 }
 
 func NewStateFromDisk(dataDir string) (*State, error) {
@@ -28,6 +33,20 @@ func NewStateFromDisk(dataDir string) (*State, error) {
 		return nil, err
 	}
 
+	return newStateFromDisk(dataDir, false)
+}
+
+// Loads the state of an existing data dir without writing to it,
+// e.g. to read the data dir of a running node. Blocks can't be imported
+func NewStateFromDiskReadOnly(dataDir string) (*State, error) {
+	if !IsDataDirInitialized(dataDir) {
+		return nil, fmt.Errorf("data dir '%s' is not initialized", dataDir)
+	}
+
+	return newStateFromDisk(dataDir, true)
+}
+
+func newStateFromDisk(dataDir string, readOnly bool) (*State, error) {
 	gen, err := loadGenesis(getGenesisJsonFilePath(dataDir))
 	if err != nil {
 		return nil, err
@@ -40,46 +59,85 @@ func NewStateFromDisk(dataDir string) (*State, error) {
 
 	account2nonce := make(map[common.Address]uint)
 
-	dbFilepath := getBlocksDbFilePath(dataDir)
-	f, err := os.OpenFile(dbFilepath, os.O_APPEND|os.O_RDWR, 0600)
+	store, err := openStorage(dataDir, readOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(f)
-
-	state := &State{balances, account2nonce, f, Block{}, Hash{}, false}
-
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	state := &State{Balances: balances, Account2Nonce: account2nonce, params: gen.ChainParams, store: store, importMu: &sync.Mutex{}, readOnly: readOnly, minerStats: &minerStatsCache{}}
 
-		blockFsJson := scanner.Bytes()
+	fromHeight, err := state.restoreSnapshot()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-		if len(blockFsJson) == 0 {
-			break
+	// Replay only the canonical chain, side branches stay in the storage
+	err = store.Iterate(fromHeight, func(hash Hash, b Block) error {
+		blockHash, err := b.Hash()
+		if err != nil {
+			return err
 		}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(blockFsJson, &blockFs)
-		if err != nil {
-			return nil, err
+		if blockHash != hash {
+			return fmt.Errorf("block %d is stored as '%s' but hashes to '%s'. The data dir was created by a version hashing whole blocks instead of their headers, sync a new data dir", b.Header.Number, hash.Hex(), blockHash.Hex())
 		}
 
-		err = applyBlock(blockFs.Value, state)
+		err = applyBlock(b, state)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		state.latestBlock = blockFs.Value
-		state.latestBlockHash = blockFs.Key
+		state.latestBlock = b
+		state.latestBlockHash = hash
 		state.hasGenesisBlock = true
+
+		return state.takeSnapshotIfDue()
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
 
 	return state, nil
 }
 
+// Loads the latest valid snapshot into the state and returns
+// the height the canonical chain replay must continue from
+func (s *State) restoreSnapshot() (uint64, error) {
+	snapshot, found, err := loadLatestValidSnapshot(s.store)
+	if err != nil {
+		return 0, err
+	}
+
+	if !found {
+		return 0, nil
+	}
+
+	b, err := s.store.GetBlock(snapshot.BlockHash)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Restoring state snapshot at height %d\n", snapshot.BlockNumber)
+
+	s.Balances = make(map[common.Address]uint)
+	for account, balance := range snapshot.Balances {
+		s.Balances[account] = balance
+	}
+
+	s.Account2Nonce = make(map[common.Address]uint)
+	for account, nonce := range snapshot.Account2Nonce {
+		s.Account2Nonce[account] = nonce
+	}
+
+	s.latestBlock = b
+	s.latestBlockHash = snapshot.BlockHash
+	s.hasGenesisBlock = true
+
+	return snapshot.BlockNumber + 1, nil
+}
+
 func (s *State) AddBlocks(blocks []Block) error {
 	for _, b := range blocks {
 		_, err := s.AddBlock(b)
@@ -92,40 +150,240 @@ func (s *State) AddBlocks(blocks []Block) error {
 }
 
 func (s *State) AddBlock(b Block) (Hash, error) {
-	pendingState := s.Copy()
+	hash, _, err := s.ImportBlock(b)
+	return hash, err
+}
 
-	err := applyBlock(b, &pendingState)
-	if err != nil {
-		return Hash{}, err
+// Adds the block into the block tree and persists it. When the block
+// makes its branch the heaviest chain, the state is reorganized onto it
+// and the TXs of the abandoned blocks not included in the new branch are returned
+func (s *State) ImportBlock(b Block) (Hash, []SignedTx, error) {
+	s.importMu.Lock()
+	defer s.importMu.Unlock()
+
+	if s.readOnly {
+		return Hash{}, nil, fmt.Errorf("state is opened read-only")
 	}
 
 	blockHash, err := b.Hash()
 	if err != nil {
-		return Hash{}, err
+		return Hash{}, nil, err
 	}
 
-	blockFs := BlockFS{blockHash, b}
+	if s.HasBlock(blockHash) {
+		return blockHash, nil, nil
+	}
 
-	blockFsJson, err := json.Marshal(blockFs)
+	err = validateBlockTime(b, s)
 	if err != nil {
-		return Hash{}, err
+		return Hash{}, nil, err
 	}
 
-	fmt.Printf("\nPersisting new block to disk:\n")
-	fmt.Printf("\t%s\n", blockFsJson)
+	if !s.hasGenesisBlock || reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+		pendingState := s.Copy()
+
+		err = applyBlock(b, &pendingState)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		err = s.store.PutBlock(blockHash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		err = indexBlockTXs(s.store, blockHash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		err = s.store.SetCanonical(b.Header.Number, []Hash{blockHash})
+		if err != nil {
+			return Hash{}, nil, err
+		}
 
-	_, err = s.dbFile.Write(append(blockFsJson, '\n'))
+		s.Balances = pendingState.Balances
+		s.Account2Nonce = pendingState.Account2Nonce
+		s.latestBlockHash = blockHash
+		s.latestBlock = b
+		s.hasGenesisBlock = true
+
+		return blockHash, nil, s.takeSnapshotIfDue()
+	}
+
+	err = s.validateSideBlock(b, blockHash)
 	if err != nil {
-		return Hash{}, err
+		return Hash{}, nil, err
+	}
+
+	// The heaviest chain wins, the current head is kept on equal total difficulties
+	head, _ := s.store.GetBlockMeta(s.latestBlockHash)
+	if newBlockMeta(s.store, blockHash, b).TotalDifficulty <= head.TotalDifficulty {
+		fmt.Printf("\nStoring side branch block '%s' at height %d\n", blockHash.Hex(), b.Header.Number)
+
+		return blockHash, nil, s.store.PutBlock(blockHash, b)
+	}
+
+	return s.reorg(b, blockHash)
+}
+
+// Switches the canonical chain to the branch ending with the given block.
+// The current chain is rolled back to the common ancestor and the branch
+// blocks are applied on top of it, the state is left untouched on failure
+func (s *State) reorg(b Block, blockHash Hash) (Hash, []SignedTx, error) {
+	branch := []Block{b}
+	branchHashes := []Hash{blockHash}
+	ancestorHash := b.Header.Parent
+
+	for !ancestorHash.IsEmpty() && !isCanonical(s.store, ancestorHash) {
+		ancestor, err := s.store.GetBlock(ancestorHash)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		branch = append([]Block{ancestor}, branch...)
+		branchHashes = append([]Hash{ancestorHash}, branchHashes...)
+		ancestorHash = ancestor.Header.Parent
+	}
+
+	forkHeight := branch[0].Header.Number
+	pendingState := s.Copy()
+	reverted := make([]Block, 0)
+
+	for i := int(s.store.Height()) - 1; i >= int(forkHeight); i-- {
+		canonicalHash, _ := s.store.GetCanonicalHash(uint64(i))
+
+		block, err := s.store.GetBlock(canonicalHash)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		revertBlock(block, &pendingState)
+
+		pendingState.latestBlock = Block{}
+		if i > 0 {
+			pendingState.latestBlock, err = s.store.GetBlock(block.Header.Parent)
+			if err != nil {
+				return Hash{}, nil, err
+			}
+		}
+		pendingState.latestBlockHash = block.Header.Parent
+		pendingState.hasGenesisBlock = i > 0
+		reverted = append(reverted, block)
+	}
+
+	for i, block := range branch {
+		err := applyBlock(block, &pendingState)
+		if err != nil {
+			// The invalid block and its stored descendants would otherwise be taken for known blocks
+			for _, hash := range branchHashes[i : len(branchHashes)-1] {
+				deleteErr := s.store.DeleteBlock(hash)
+				if deleteErr != nil {
+					fmt.Printf("ERROR: %s\n", deleteErr)
+				}
+			}
+
+			return Hash{}, nil, fmt.Errorf("unable to reorganize chain onto block '%s'. %s", blockHash.Hex(), err.Error())
+		}
+
+		pendingState.latestBlock = block
+		pendingState.latestBlockHash = branchHashes[i]
+		pendingState.hasGenesisBlock = true
 	}
 
+	err := s.store.PutBlock(blockHash, b)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	for i, block := range branch {
+		err = indexBlockTXs(s.store, branchHashes[i], block)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+	}
+
+	err = s.store.SetCanonical(forkHeight, branchHashes)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	orphaned := orphanedTXs(reverted, branch)
+	err = unindexTXs(s.store, orphaned)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	fmt.Printf("\nReorganized chain from '%s' to '%s', %d blocks reverted, %d applied\n", s.latestBlockHash.Hex(), blockHash.Hex(), len(reverted), len(branch))
+
 	s.Balances = pendingState.Balances
 	s.Account2Nonce = pendingState.Account2Nonce
 	s.latestBlockHash = blockHash
 	s.latestBlock = b
 	s.hasGenesisBlock = true
 
-	return blockHash, nil
+	return blockHash, orphaned, s.takeSnapshotIfDue()
+}
+
+// Verifies the block links correctly into a known branch of the block tree and its
+// body matches its header. Its TXs are only applied when its branch becomes the heaviest
+func (s *State) validateSideBlock(b Block, blockHash Hash) error {
+	if b.Header.Parent.IsEmpty() {
+		if b.Header.Number != 0 {
+			return fmt.Errorf("block without a parent must be the genesis block, not '%d'", b.Header.Number)
+		}
+	} else {
+		parent, isKnown := s.store.GetBlockMeta(b.Header.Parent)
+		if !isKnown {
+			return fmt.Errorf("unknown parent block '%s'", b.Header.Parent.Hex())
+		}
+
+		if b.Header.Number != parent.Number+1 {
+			return fmt.Errorf("next expected block must be '%d' not '%d'", parent.Number+1, b.Header.Number)
+		}
+	}
+
+	err := validateProofOfWork(b, blockHash, s)
+	if err != nil {
+		return err
+	}
+
+	err = validateBlockLimits(b, s.params)
+	if err != nil {
+		return err
+	}
+
+	return validateTxRoot(b)
+}
+
+// Computes the state root of a block mined with the given TXs on top of the latest block
+func (s *State) NextStateRoot(miner common.Address, txs []SignedTx) (Hash, error) {
+	pendingState := s.Copy()
+
+	err := applyBlockTXs(miner, txs, &pendingState)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return pendingState.StateRoot(), nil
+}
+
+func (s *State) HasBlock(hash Hash) bool {
+	_, isKnown := s.store.GetBlockMeta(hash)
+	return isKnown
+}
+
+func (s *State) GetBlockByHash(hash Hash) (Block, error) {
+	return s.store.GetBlock(hash)
+}
+
+func (s *State) GetBlockByHeight(height uint64) (Block, error) {
+	hash, ok := s.store.GetCanonicalHash(height)
+	if !ok {
+		return Block{}, fmt.Errorf("no block at height %d", height)
+	}
+
+	return s.store.GetBlock(hash)
 }
 
 func (s *State) NextBlockNumber() uint64 {
@@ -136,6 +394,10 @@ func (s *State) NextBlockNumber() uint64 {
 	return s.LatestBlock().Header.Number + 1
 }
 
+func (s *State) ChainParams() ChainParams {
+	return s.params
+}
+
 func (s *State) LatestBlock() Block {
 	return s.latestBlock
 }
@@ -149,11 +411,16 @@ func (s *State) GetNextAccountNonce(account common.Address) uint {
 }
 
 func (s *State) Close() error {
-	return s.dbFile.Close()
+	return s.store.Close()
 }
 
 func (s *State) Copy() State {
 	c := State{}
+	c.params = s.params
+	c.store = s.store
+	c.importMu = s.importMu
+	c.readOnly = s.readOnly
+	c.minerStats = s.minerStats
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
@@ -177,40 +444,115 @@ func (s *State) Copy() State {
 func applyBlock(b Block, s *State) error {
 	nextExpectedBlockNumber := s.latestBlock.Header.Number + 1
 
+	if !s.hasGenesisBlock && b.Header.Number != 0 {
+		return fmt.Errorf("first block must be the genesis block '0' not '%d'", b.Header.Number)
+	}
+
 	if s.hasGenesisBlock && b.Header.Number != nextExpectedBlockNumber {
 		return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
 	}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+	if s.hasGenesisBlock && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
 		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
 	}
 
+	err := validateBlockLimits(b, s.params)
+	if err != nil {
+		return err
+	}
+
 	hash, err := b.Hash()
 	if err != nil {
 		return err
 	}
 
-	if !IsBlockHashValid(hash) {
+	err = validateProofOfWork(b, hash, s)
+	if err != nil {
+		return err
+	}
+
+	err = validateTxRoot(b)
+	if err != nil {
+		return err
+	}
+
+	err = applyBlockTXs(b.Header.Miner, b.TXs, s)
+	if err != nil {
+		return err
+	}
+
+	stateRoot := s.StateRoot()
+	if !reflect.DeepEqual(b.Header.StateRoot, stateRoot) {
+		return fmt.Errorf("block state root must be '%x' not '%x'", stateRoot, b.Header.StateRoot)
+	}
+
+	return nil
+}
+
+func validateBlockLimits(b Block, params ChainParams) error {
+	if uint64(len(b.TXs)) > params.MaxBlockTXs {
+		return fmt.Errorf("block can contain at most %d TXs, not %d", params.MaxBlockTXs, len(b.TXs))
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return err
+	}
+
+	if size > params.MaxBlockSize {
+		return fmt.Errorf("block size must be at most %d bytes, not %d", params.MaxBlockSize, size)
+	}
+
+	return nil
+}
+
+// Verifies the block TXs are the ones its header commits to
+func validateTxRoot(b Block) error {
+	txRoot, err := TxsMerkleRoot(b.TXs)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(b.Header.TxRoot, txRoot) {
+		return fmt.Errorf("block TXs merkle root must be '%x' not '%x'", txRoot, b.Header.TxRoot)
+	}
+
+	return nil
+}
+
+// Verifies the block is mined with the difficulty expected on its branch
+func validateProofOfWork(b Block, hash Hash, s *State) error {
+	difficulty, err := s.expectedDifficulty(b.Header.Parent, b.Header.Number)
+	if err != nil {
+		return err
+	}
+
+	if b.Header.Difficulty != difficulty {
+		return fmt.Errorf("block difficulty must be '%d' not '%d'", difficulty, b.Header.Difficulty)
+	}
+
+	if !IsBlockHashValid(hash, difficulty) {
 		return fmt.Errorf("invalid block hash %x", hash)
 	}
 
-	err = applyTXs(b.TXs, s)
+	return nil
+}
+
+// Applies the TXs and credits the miner with the block reward and TX fees
+func applyBlockTXs(miner common.Address, txs []SignedTx, s *State) error {
+	err := applyTXs(txs, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
-	s.Balances[b.Header.Miner] += uint(len(b.TXs)) * TxFee
+	s.Balances[miner] += s.params.BlockReward
+	s.Balances[miner] += totalFees(txs)
 
 	return nil
 }
 
 func applyTXs(txs []SignedTx, s *State) error {
-	sort.Slice(txs, func(i, j int) bool {
-		return txs[i].Time < txs[j].Time
-	})
-
-	for _, tx := range txs {
+	for _, tx := range sortTXsByTime(txs) {
 		err := ApplyTx(tx, s)
 		if err != nil {
 			return err
@@ -220,6 +562,67 @@ func applyTXs(txs []SignedTx, s *State) error {
 	return nil
 }
 
+// Rolls back the block's balance and nonce changes, the inverse of applyBlock
+func revertBlock(b Block, s *State) {
+	s.Balances[b.Header.Miner] -= s.params.BlockReward
+	s.Balances[b.Header.Miner] -= totalFees(b.TXs)
+
+	txs := sortTXsByTime(b.TXs)
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+
+		s.Balances[tx.To] -= tx.Value
+		s.Balances[tx.From] += tx.Cost()
+		s.Account2Nonce[tx.From] = tx.Nonce - 1
+	}
+}
+
+func totalFees(txs []SignedTx) uint {
+	fees := uint(0)
+	for _, tx := range txs {
+		fees += tx.Fee
+	}
+
+	return fees
+}
+
+// Sorts a copy of the TXs so the block's own TXs order stays untouched
+func sortTXsByTime(txs []SignedTx) []SignedTx {
+	sorted := make([]SignedTx, len(txs))
+	copy(sorted, txs)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	return sorted
+}
+
+// Collects TXs of the reverted blocks which didn't make it into the new branch
+func orphanedTXs(reverted []Block, applied []Block) []SignedTx {
+	included := make(map[Hash]bool)
+	for _, b := range applied {
+		for _, tx := range b.TXs {
+			txHash, err := tx.Hash()
+			if err == nil {
+				included[txHash] = true
+			}
+		}
+	}
+
+	orphaned := make([]SignedTx, 0)
+	for _, b := range reverted {
+		for _, tx := range b.TXs {
+			txHash, err := tx.Hash()
+			if err == nil && !included[txHash] {
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+
+	return orphaned
+}
+
 func ApplyTx(tx SignedTx, s *State) error {
 	err := ValidateTx(tx, s)
 	if err != nil {
@@ -234,15 +637,11 @@ func ApplyTx(tx SignedTx, s *State) error {
 }
 
 func ValidateTx(tx SignedTx, s *State) error {
-	ok, err := tx.IsAuthentic()
+	err := ValidateTxIntrinsic(tx, s.params)
 	if err != nil {
 		return err
 	}
 
-	if !ok {
-		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
-	}
-
 	expectedNonce := s.GetNextAccountNonce(tx.From)
 	if tx.Nonce != expectedNonce {
 		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
@@ -252,5 +651,32 @@ func ValidateTx(tx SignedTx, s *State) error {
 		return fmt.Errorf("wrong TX. Sender '%s' balance is %d tokens. Tx cost is %d tokens", tx.From.String(), s.Balances[tx.From], tx.Cost())
 	}
 
+	return nil
+}
+
+// Validates what doesn't depend on the sender's nonce and balance
+func ValidateTxIntrinsic(tx SignedTx, params ChainParams) error {
+	ok, err := tx.IsAuthentic()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
+	}
+
+	if tx.ChainID != params.ChainID {
+		return fmt.Errorf("wrong TX. TX is signed for chain '%s', not '%s'", tx.ChainID, params.ChainID)
+	}
+
+	if tx.Fee < params.MinTxFee {
+		return fmt.Errorf("wrong TX. TX fee must be at least %d tokens, not %d", params.MinTxFee, tx.Fee)
+	}
+
+	// The cost would wrap around and let the TX spend more than the sender's balance
+	if tx.Fee > ^uint(0)-tx.Value {
+		return fmt.Errorf("wrong TX. TX value %d and fee %d overflow its cost", tx.Value, tx.Fee)
+	}
+
 	return nil
 }
\ No newline at end of file
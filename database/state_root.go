@@ -0,0 +1,73 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Computes the Merkle root over all accounts sorted by address.
+// Accounts without balance and nonce are left out of the tree
+func (s *State) StateRoot() Hash {
+	_, leaves := s.accountsLeaves()
+	return merkleRoot(leaves)
+}
+
+// Builds the proof of the account's balance and nonce against the state root
+func (s *State) AccountProof(account common.Address) ([]MerkleProofNode, error) {
+	accounts, leaves := s.accountsLeaves()
+
+	for i, acc := range accounts {
+		if acc == account {
+			return merkleProof(leaves, i), nil
+		}
+	}
+
+	return nil, fmt.Errorf("account '%s' has no balance nor nonce", account.String())
+}
+
+// Verifies an account proof against a block header state root
+func VerifyAccountProof(account common.Address, balance uint, nonce uint, proof []MerkleProofNode, root Hash) bool {
+	return verifyMerkleProof(accountLeaf(account, balance, nonce), proof, root)
+}
+
+func (s *State) accountsLeaves() ([]common.Address, []Hash) {
+	accounts := make([]common.Address, 0, len(s.Balances))
+	for account, balance := range s.Balances {
+		if balance > 0 || s.Account2Nonce[account] > 0 {
+			accounts = append(accounts, account)
+		}
+	}
+
+	for account, nonce := range s.Account2Nonce {
+		if nonce > 0 && s.Balances[account] == 0 {
+			accounts = append(accounts, account)
+		}
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i][:], accounts[j][:]) < 0
+	})
+
+	leaves := make([]Hash, len(accounts))
+	for i, account := range accounts {
+		leaves[i] = accountLeaf(account, s.Balances[account], s.Account2Nonce[account])
+	}
+
+	return accounts, leaves
+}
+
+func accountLeaf(account common.Address, balance uint, nonce uint) Hash {
+	leaf := make([]byte, 0, common.AddressLength+16)
+	leaf = append(leaf, account[:]...)
+
+	numbers := make([]byte, 16)
+	binary.BigEndian.PutUint64(numbers[0:8], uint64(balance))
+	binary.BigEndian.PutUint64(numbers[8:16], uint64(nonce))
+
+	return sha256.Sum256(append(leaf, numbers...))
+}
@@ -0,0 +1,844 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Low enough for the tests to mine blocks instantly
+const testDifficulty = 64
+
+func TestState_Reorg(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			miner1 := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+			miner2 := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+			// Two nodes sharing the genesis block, each mining its own branch
+			state, dataDir := newTestState(t, storage)
+			defer os.RemoveAll(dataDir)
+
+			peerState, peerDataDir := newTestState(t, storage)
+			defer os.RemoveAll(peerDataDir)
+
+			genesisBlock := mineTestBlock(t, state, miner1, 1)
+			addTestBlock(t, state, genesisBlock)
+			addTestBlock(t, peerState, genesisBlock)
+
+			addTestBlock(t, state, mineTestBlock(t, state, miner1, 2))
+
+			peerBlock1 := mineTestBlock(t, peerState, miner2, 3)
+			addTestBlock(t, peerState, peerBlock1)
+			addTestBlock(t, state, peerBlock1)
+
+			reward := state.ChainParams().BlockReward
+			if state.Balances[miner1] != 2*reward || state.Balances[miner2] != 0 {
+				t.Fatalf("a competing block of the same height must not replace the head")
+			}
+
+			minersStats, err := state.MinerStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(minersStats) != 1 || minersStats[0].Miner != miner1 || minersStats[0].Blocks != 2 {
+				t.Fatalf("miners stats must count the canonical blocks, got %+v", minersStats)
+			}
+
+			peerBlock2 := mineTestBlock(t, peerState, miner2, 4)
+			addTestBlock(t, peerState, peerBlock2)
+			peerHead := addTestBlock(t, state, peerBlock2)
+
+			if state.LatestBlockHash() != peerHead {
+				t.Fatalf("the heavier branch must become the canonical chain")
+			}
+
+			if state.Balances[miner1] != reward || state.Balances[miner2] != 2*reward {
+				t.Fatalf("balances must be rolled back and forward by the reorg")
+			}
+
+			// The cached stats of the abandoned head are recomputed
+			minersStats, err = state.MinerStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(minersStats) != 2 || minersStats[0].Miner != miner2 || minersStats[0].Blocks != 2 || minersStats[1].Blocks != 1 {
+				t.Fatalf("miners stats must only count the canonical blocks")
+			}
+
+			summary, err := state.ChainSummary()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if summary.Height != 3 || summary.TotalSupply != 3*reward || summary.AverageBlockTime != 1.5 {
+				t.Fatalf("chain summary must describe the canonical chain, got %+v", summary)
+			}
+
+			err = state.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reloadedState, err := NewStateFromDisk(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reloadedState.Close()
+
+			if reloadedState.LatestBlockHash() != peerHead || reloadedState.Balances[miner2] != 2*reward {
+				t.Fatalf("the reorganized chain must be restored from disk")
+			}
+
+			_, err = reloadedState.MinerStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			peerBlock3 := mineTestBlock(t, peerState, miner2, 5)
+			addTestBlock(t, peerState, peerBlock3)
+			addTestBlock(t, reloadedState, peerBlock3)
+
+			minersStats, err = reloadedState.MinerStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if minersStats[0].Blocks != 3 || minersStats[0].LastBlockNumber != 3 {
+				t.Fatalf("miners stats must count the blocks imported since, got %+v", minersStats)
+			}
+
+			peerState.Close()
+		})
+	}
+}
+
+func TestState_SideBlockValidation(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			key, sender := newTestKey(t)
+			miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+			state, dataDir := newTestState(t, storage)
+			defer os.RemoveAll(dataDir)
+
+			genesisBlock := mineTestBlock(t, state, sender, 1)
+			genesisHash := addTestBlock(t, state, genesisBlock)
+			addTestBlock(t, state, mineTestBlock(t, state, miner, 2))
+			head := addTestBlock(t, state, mineTestBlock(t, state, miner, 3))
+
+			// The sender only owns the genesis block reward
+			overspendingTx := signTestTx(t, NewTx(sender, miner, 10*state.ChainParams().BlockReward, 1, ""), key)
+			sideBlock1 := mineTestBlockOnParent(t, genesisHash, 1, testDifficulty, miner, 2, Hash{}, overspendingTx)
+			sideHash1, err := sideBlock1.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Same header, other body
+			fakeBlock := sideBlock1
+			fakeBlock.TXs = nil
+			if _, err := state.AddBlock(fakeBlock); err == nil {
+				t.Fatalf("side block whose TXs don't match its TX root must be rejected")
+			}
+
+			if state.HasBlock(sideHash1) {
+				t.Fatalf("side block with a fake body must not be stored")
+			}
+
+			addTestBlock(t, state, sideBlock1)
+			sideBlock2 := mineTestBlockOnParent(t, sideHash1, 2, testDifficulty, miner, 3, Hash{})
+			sideHash2 := addTestBlock(t, state, sideBlock2)
+
+			if state.LatestBlockHash() != head || !state.HasBlock(sideHash1) || !state.HasBlock(sideHash2) {
+				t.Fatalf("side blocks not heavier than the head must be stored")
+			}
+
+			sideBlock3 := mineTestBlockOnParent(t, sideHash2, 3, testDifficulty, miner, 4, Hash{})
+			if _, err := state.AddBlock(sideBlock3); err == nil {
+				t.Fatalf("reorg onto a branch with an invalid TX must fail")
+			}
+
+			if state.LatestBlockHash() != head || state.HasBlock(sideHash1) || state.HasBlock(sideHash2) {
+				t.Fatalf("blocks of the branch failing the reorg must be deleted")
+			}
+
+			state = reopenTestState(t, state, dataDir)
+			if state.LatestBlockHash() != head || state.HasBlock(sideHash1) || state.HasBlock(sideHash2) {
+				t.Fatalf("deleted blocks must stay deleted once reopened")
+			}
+
+			err = state.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The index rebuilt from block.db must skip the deleted blocks
+			if storage == StorageFile {
+				err = os.Remove(getBlocksIndexFilePath(dataDir))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			state, err = NewStateFromDisk(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.Close()
+
+			if state.LatestBlockHash() != head || state.HasBlock(sideHash1) {
+				t.Fatalf("deleted blocks must not be restored")
+			}
+		})
+	}
+}
+
+func TestState_ConcurrentImports(t *testing.T) {
+	miner1 := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+	miner2 := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	// Two competing branches on top of the same genesis block, imported at the same time
+	branches := make([][]Block, 2)
+	for i, miner := range []common.Address{miner1, miner2} {
+		peerState, peerDataDir := newTestState(t, StorageFile)
+		defer os.RemoveAll(peerDataDir)
+		defer peerState.Close()
+
+		for number := uint64(0); number < uint64(5+i); number++ {
+			blockMiner := miner
+			if number == 0 {
+				blockMiner = miner1
+			}
+
+			b := mineTestBlock(t, peerState, blockMiner, number+1)
+			addTestBlock(t, peerState, b)
+			branches[i] = append(branches[i], b)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(branches))
+	for i, branch := range branches {
+		wg.Add(1)
+		go func(i int, branch []Block) {
+			defer wg.Done()
+			errs[i] = state.AddBlocks(branch)
+		}(i, branch)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reward := state.ChainParams().BlockReward
+	if state.LatestBlock().Header.Number != 5 || state.Balances[miner1] != reward || state.Balances[miner2] != 5*reward {
+		t.Fatalf("the heavier branch must become the canonical chain, got balances %v", state.Balances)
+	}
+}
+
+func TestState_ReorgRevertsTXs(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			key, sender := newTestKey(t)
+			miner1 := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+			miner2 := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+			recipient := NewAccount("0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A")
+
+			// The sender's block reward pays for both TXs
+			params := ChainParams{Difficulty: testDifficulty, BlockReward: 1000}
+
+			state, dataDir := newTestStateWithParams(t, storage, params)
+			defer os.RemoveAll(dataDir)
+			defer state.Close()
+
+			peerState, peerDataDir := newTestStateWithParams(t, storage, params)
+			defer os.RemoveAll(peerDataDir)
+			defer peerState.Close()
+
+			genesisBlock := mineTestBlock(t, state, sender, 1)
+			addTestBlock(t, state, genesisBlock)
+			addTestBlock(t, peerState, genesisBlock)
+
+			// The shared TX is mined on both branches, the orphaned one only on the abandoned branch
+			sharedTx := signTestTx(t, NewTx(sender, recipient, 10, 1, ""), key)
+			orphanedTx := signTestTx(t, NewTx(sender, recipient, 20, 2, ""), key)
+			addTestBlock(t, state, mineTestBlock(t, state, miner1, 2, sharedTx, orphanedTx))
+
+			peerBlock1 := mineTestBlock(t, peerState, miner2, 3, sharedTx)
+			addTestBlock(t, peerState, peerBlock1)
+			addTestBlock(t, state, peerBlock1)
+
+			peerBlock2 := mineTestBlock(t, peerState, miner2, 4)
+			addTestBlock(t, peerState, peerBlock2)
+
+			_, orphaned, err := state.ImportBlock(peerBlock2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			orphanedTxHash, err := orphanedTx.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(orphaned) != 1 {
+				t.Fatalf("only the TX missing from the new branch must be orphaned, got %d TXs", len(orphaned))
+			}
+
+			if hash, _ := orphaned[0].Hash(); hash != orphanedTxHash {
+				t.Fatalf("orphaned TX must be %s, got %s", orphanedTxHash.Hex(), hash.Hex())
+			}
+
+			reward := state.ChainParams().BlockReward
+			if state.Balances[sender] != reward-sharedTx.Cost() || state.Balances[recipient] != sharedTx.Value || state.Account2Nonce[sender] != 1 {
+				t.Fatalf("the abandoned branch TXs must be reverted, sender balance %d, recipient balance %d", state.Balances[sender], state.Balances[recipient])
+			}
+
+			if state.Balances[miner1] != 0 || state.Balances[miner2] != 2*reward+sharedTx.Fee {
+				t.Fatalf("the abandoned branch miner must lose its reward and fees")
+			}
+
+			_, _, isMined, err := state.GetTx(orphanedTxHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if isMined {
+				t.Fatalf("orphaned TX must no longer be indexed as mined")
+			}
+		})
+	}
+}
+
+func TestState_TxIndex(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			key, sender := newTestKey(t)
+			miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+			state, dataDir := newTestState(t, storage)
+			defer os.RemoveAll(dataDir)
+
+			peerState, peerDataDir := newTestState(t, storage)
+			defer os.RemoveAll(peerDataDir)
+
+			genesisBlock := mineTestBlock(t, state, sender, 1)
+			addTestBlock(t, state, genesisBlock)
+			addTestBlock(t, peerState, genesisBlock)
+
+			tx := signTestTx(t, NewTx(sender, miner, 10, 1, ""), key)
+			txHash, err := tx.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			txBlockHash := addTestBlock(t, state, mineTestBlock(t, state, sender, 2, tx))
+			addTestBlock(t, state, mineTestBlock(t, state, sender, 3))
+
+			minedTx, location, isMined, err := state.GetTx(txHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !isMined || location.BlockHash != txBlockHash || location.BlockNumber != 1 || location.Index != 0 {
+				t.Fatalf("TX '%s' must be indexed in block '%s'", txHash.Hex(), txBlockHash.Hex())
+			}
+
+			if minedTxHash, _ := minedTx.Hash(); minedTxHash != txHash {
+				t.Fatalf("indexed TX must be '%s' not '%s'", txHash.Hex(), minedTxHash.Hex())
+			}
+
+			if state.Confirmations(location.BlockHash) != 2 {
+				t.Fatalf("TX must have 2 confirmations, not %d", state.Confirmations(location.BlockHash))
+			}
+
+			senderTXs, total, err := state.GetAccountTXs(sender, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if total != 1 || senderTXs[0].Hash != txHash || senderTXs[0].Direction != TxDirectionOut || senderTXs[0].Counterparty != miner {
+				t.Fatalf("TX '%s' must be listed as sent by '%s'", txHash.Hex(), sender.String())
+			}
+
+			minerTXs, _, err := state.GetAccountTXs(miner, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(minerTXs) != 1 || minerTXs[0].Direction != TxDirectionIn || minerTXs[0].Counterparty != sender {
+				t.Fatalf("TX '%s' must be listed as received by '%s'", txHash.Hex(), miner.String())
+			}
+
+			for i := uint64(0); i < 3; i++ {
+				b := mineTestBlock(t, peerState, miner, 4+i)
+				addTestBlock(t, peerState, b)
+				addTestBlock(t, state, b)
+			}
+
+			if _, isMined := state.GetTxLocation(txHash); isMined {
+				t.Fatalf("TX of a reverted block must be removed from the index")
+			}
+
+			if _, total, _ := state.GetAccountTXs(sender, 0, 10); total != 0 {
+				t.Fatalf("TX of a reverted block must be removed from the accounts index")
+			}
+
+			err = state.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reloadedState, err := NewStateFromDisk(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reloadedState.Close()
+
+			if _, isMined := reloadedState.GetTxLocation(txHash); isMined {
+				t.Fatalf("TX removed from the index must stay removed after a restart")
+			}
+
+			peerState.Close()
+		})
+	}
+}
+
+func TestState_TxFees(t *testing.T) {
+	key, sender := newTestKey(t)
+	miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+
+	addTestBlock(t, state, mineTestBlock(t, state, sender, 1))
+
+	pendingState := state.Copy()
+	cheapTx := signTestTx(t, NewTx(sender, miner, 10, 1, "").WithFee(DefaultMinTxFee-1), key)
+	if err := ApplyTx(cheapTx, &pendingState); err == nil {
+		t.Fatalf("TX paying less than the min fee must be rejected")
+	}
+
+	// Its cost wraps around to fewer tokens than the sender owns
+	overflowingTx := signTestTx(t, NewTx(sender, miner, ^uint(0)-10, 1, ""), key)
+	if err := ApplyTx(overflowingTx, &pendingState); err == nil {
+		t.Fatalf("TX whose value and fee overflow its cost must be rejected")
+	}
+
+	tx := signTestTx(t, NewTx(sender, miner, 10, 1, "").WithFee(DefaultMinTxFee+20), key)
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 2, tx))
+
+	if state.Balances[sender] != DefaultBlockReward-tx.Cost() {
+		t.Fatalf("sender must pay the TX value and its fee, balance is %d", state.Balances[sender])
+	}
+
+	if state.Balances[miner] != DefaultBlockReward+tx.Value+tx.Fee {
+		t.Fatalf("miner must be credited the block reward and the TX fee, balance is %d", state.Balances[miner])
+	}
+}
+
+func TestState_ChainID(t *testing.T) {
+	key, sender := newTestKey(t)
+	miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	otherState, otherDataDir := newTestStateWithParams(t, StorageFile, ChainParams{ChainID: "other", Difficulty: testDifficulty})
+	defer os.RemoveAll(otherDataDir)
+	defer otherState.Close()
+
+	// Blocks carry no chain ID, the first one is valid on both chains
+	b := mineTestBlock(t, otherState, sender, 1)
+	addTestBlock(t, otherState, b)
+	addTestBlock(t, state, b)
+
+	otherTx := signTestTx(t, NewTx(sender, miner, 10, 1, "").WithChainID("other"), key)
+
+	pendingState := state.Copy()
+	if err := ApplyTx(otherTx, &pendingState); err == nil {
+		t.Fatalf("TX signed for another chain must be rejected")
+	}
+
+	otherBlock := mineTestBlock(t, otherState, miner, 2, otherTx)
+	addTestBlock(t, otherState, otherBlock)
+
+	if _, err := state.AddBlock(otherBlock); err == nil {
+		t.Fatalf("block with a TX signed for another chain must be rejected")
+	}
+
+	if state.HasBlock(otherState.LatestBlockHash()) || state.LatestBlock().Header.Number != 0 {
+		t.Fatalf("block with a TX signed for another chain must not be stored")
+	}
+}
+
+func TestState_BlockLimits(t *testing.T) {
+	key, sender := newTestKey(t)
+	miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestStateWithParams(t, StorageFile, ChainParams{Difficulty: testDifficulty, MaxBlockTXs: 1, MaxBlockSize: 1024})
+	defer os.RemoveAll(dataDir)
+
+	addTestBlock(t, state, mineTestBlock(t, state, sender, 1))
+
+	tx1 := signTestTx(t, NewTx(sender, miner, 0, 1, ""), key)
+	tx2 := signTestTx(t, NewTx(sender, miner, 0, 2, ""), key)
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, 2, tx1, tx2)); err == nil {
+		t.Fatalf("block with more TXs than the max block TXs must be rejected")
+	}
+
+	bigTx := signTestTx(t, NewTx(sender, miner, 1, 1, strings.Repeat("x", 1024)), key)
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, 2, bigTx)); err == nil {
+		t.Fatalf("block larger than the max block size must be rejected")
+	}
+
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 2, tx1))
+}
+
+func TestState_HeaderSync(t *testing.T) {
+	miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	genesisHash := addTestBlock(t, state, mineTestBlock(t, state, miner, 1))
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 2))
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 3))
+
+	headers, err := state.GetHeadersAfter(Hash{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 3 || headers[0].Key != genesisHash || headers[2].Key != state.LatestBlockHash() {
+		t.Fatalf("all the canonical headers must follow the empty hash, got %d", len(headers))
+	}
+
+	err = VerifyHeaderChain(Hash{}, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := state.GetHeadersAfter(genesisHash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page) != 1 || page[0].Key != headers[1].Key {
+		t.Fatalf("headers page must be limited and start after the given block")
+	}
+
+	forged := append([]BlockHeaderFS{}, headers...)
+	forged[1].Value.Time = 10
+	if err = VerifyHeaderChain(Hash{}, forged); err == nil {
+		t.Fatalf("header not matching its hash must be rejected")
+	}
+
+	if err = VerifyHeaderChain(Hash{}, headers[1:]); err == nil {
+		t.Fatalf("headers not linked to the parent must be rejected")
+	}
+
+	locator := state.BlockLocator()
+	if len(locator) != 3 || locator[0] != headers[2].Key || locator[2] != genesisHash {
+		t.Fatalf("block locator must list the canonical chain from the latest block, got %d hashes", len(locator))
+	}
+
+	located, err := state.GetHeadersAfterLocator([]Hash{{1}, genesisHash}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(located) != 2 || located[0].Key != headers[1].Key {
+		t.Fatalf("headers must follow the first canonical locator block")
+	}
+
+	err = state.SaveSyncProgress(SyncProgress{Headers: headers[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := state.LoadSyncProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress.Headers) != 2 || progress.Headers[0].Key != headers[1].Key {
+		t.Fatalf("sync progress must be restored, got %d headers", len(progress.Headers))
+	}
+
+	err = state.SaveSyncCursor(headers[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err = state.LoadSyncProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress.Headers) != 1 || progress.Headers[0].Key != headers[2].Key {
+		t.Fatalf("sync progress must resume after the cursor, got %d headers", len(progress.Headers))
+	}
+}
+
+func TestState_HeaderVerifier(t *testing.T) {
+	miner := NewAccount("0x6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 1))
+	addTestBlock(t, state, mineTestBlock(t, state, miner, 2))
+	parent := addTestBlock(t, state, mineTestBlock(t, state, miner, 3))
+
+	verifier := state.NewHeaderVerifier()
+
+	skipping := headerOf(t, mineTestBlockOnParent(t, parent, 4, testDifficulty, miner, 4, Hash{}))
+	if err := verifier.Verify(parent, []BlockHeaderFS{skipping}); err == nil {
+		t.Fatalf("header skipping a height must be rejected")
+	}
+
+	easier := headerOf(t, mineTestBlockOnParent(t, parent, 3, 1, miner, 4, Hash{}))
+	if err := verifier.Verify(parent, []BlockHeaderFS{easier}); err == nil {
+		t.Fatalf("header easier than its parent must be rejected")
+	}
+
+	// Blocks mined a second apart instead of the default target block time
+	headers := make([]BlockHeaderFS, 0)
+	for number := uint64(3); number < DifficultyRetargetInterval; number++ {
+		header := headerOf(t, mineTestBlockOnParent(t, parent, number, testDifficulty, miner, number+1, Hash{}))
+		headers = append(headers, header)
+		parent = header.Key
+	}
+
+	err := verifier.Verify(headers[0].Value.Parent, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retarget runs over the verified headers whose blocks aren't stored
+	unchanged := headerOf(t, mineTestBlockOnParent(t, parent, DifficultyRetargetInterval, testDifficulty, miner, DifficultyRetargetInterval+1, Hash{}))
+	if err := verifier.Verify(parent, []BlockHeaderFS{unchanged}); err == nil {
+		t.Fatalf("header ignoring the retarget must be rejected")
+	}
+
+	retargeted := headerOf(t, mineTestBlockOnParent(t, parent, DifficultyRetargetInterval, testDifficulty*maxDifficultyAdjustment, miner, DifficultyRetargetInterval+1, Hash{}))
+	err = verifier.Verify(parent, []BlockHeaderFS{retargeted})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func headerOf(t *testing.T, b Block) BlockHeaderFS {
+	hash, err := b.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return BlockHeaderFS{hash, b.Header}
+}
+
+func TestState_DataDirInUse(t *testing.T) {
+	for _, storage := range []string{StorageFile, StorageLevelDB} {
+		t.Run(storage, func(t *testing.T) {
+			state, dataDir := newTestState(t, storage)
+			defer os.RemoveAll(dataDir)
+
+			if _, err := NewStateFromDisk(dataDir); err == nil {
+				t.Fatalf("data dir opened by a running node must not be opened again")
+			}
+
+			err := state.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reopenedState, err := NewStateFromDisk(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopenedState.Close()
+		})
+	}
+}
+
+func TestState_ReadOnly(t *testing.T) {
+	miner := NewAccount("0x3eb92807f1f91a8d4d85bc908c7f86dcddb1df57")
+
+	state, dataDir := newTestState(t, StorageFile)
+	defer os.RemoveAll(dataDir)
+	defer state.Close()
+
+	for i := uint64(1); i <= 3; i++ {
+		addTestBlock(t, state, mineTestBlock(t, state, miner, i))
+	}
+
+	// The running node is halfway through appending a block and its index record
+	appendTestFile(t, getBlocksDbFilePath(dataDir), []byte(`{"hash":"0x`))
+	appendTestFile(t, getBlocksIndexFilePath(dataDir), make([]byte, blockIndexRecordSize/2))
+
+	readOnlyState, err := NewStateFromDiskReadOnly(dataDir)
+	if err != nil {
+		t.Fatalf("data dir of a running node must be readable. %s", err)
+	}
+	defer readOnlyState.Close()
+
+	if readOnlyState.LatestBlockHash() != state.LatestBlockHash() || readOnlyState.Balances[miner] != state.Balances[miner] {
+		t.Fatalf("read-only state must match the running node's state")
+	}
+
+	if _, err := readOnlyState.AddBlock(mineTestBlock(t, state, miner, 4)); err == nil {
+		t.Fatalf("read-only state must not import blocks")
+	}
+
+	if _, err := NewStateFromDiskReadOnly(filepath.Join(dataDir, "missing")); err == nil {
+		t.Fatalf("read-only state must not initialize a data dir")
+	}
+
+	// A node never ran on the data dir
+	newDataDir := filepath.Join(dataDir, "new")
+	err = InitDataDirIfNotExists(newDataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newState, err := NewStateFromDiskReadOnly(newDataDir)
+	if err != nil {
+		t.Fatalf("initialized data dir must be readable. %s", err)
+	}
+	defer newState.Close()
+
+	if !newState.LatestBlockHash().IsEmpty() || len(newState.Balances) == 0 {
+		t.Fatalf("initialized data dir must hold the genesis balances only")
+	}
+}
+
+func appendTestFile(t *testing.T, path string, content []byte) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestState(t *testing.T, storage string) (*State, string) {
+	return newTestStateWithParams(t, storage, ChainParams{Difficulty: testDifficulty})
+}
+
+func newTestStateWithParams(t *testing.T, storage string, params ChainParams) (*State, string) {
+	dataDir, err := ioutil.TempDir(os.TempDir(), "gochain_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitStorage(dataDir, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := Genesis{ChainParams: params, Balances: make(map[common.Address]uint)}
+	genesisJson, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDataDirIfNotExists(dataDir, genesisJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state, dataDir
+}
+
+func mineTestBlock(t *testing.T, s *State, miner common.Address, time uint64, txs ...SignedTx) Block {
+	stateRoot, err := s.NextStateRoot(miner, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mineTestBlockOnParent(t, s.LatestBlockHash(), s.NextBlockNumber(), s.NextDifficulty(), miner, time, stateRoot, txs...)
+}
+
+// Mines a block on any parent, its TXs and state root aren't validated
+func mineTestBlockOnParent(t *testing.T, parent Hash, number uint64, difficulty uint64, miner common.Address, time uint64, stateRoot Hash, txs ...SignedTx) Block {
+	for nonce := uint32(0); ; nonce++ {
+		b, err := NewBlock(parent, number, nonce, time, miner, difficulty, stateRoot, txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, b.Header.Difficulty) {
+			return b
+		}
+	}
+}
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func signTestTx(t *testing.T, tx Tx, key *ecdsa.PrivateKey) SignedTx {
+	txJson, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash := sha256.Sum256(txJson)
+	sig, err := crypto.Sign(txHash[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedTx(tx, sig)
+}
+
+func addTestBlock(t *testing.T, s *State, b Block) Hash {
+	hash, err := s.AddBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const StorageFile = "file"
+const StorageLevelDB = "leveldb"
+
+// Position of a stored block within the block tree. The total difficulty
+// is the sum of the difficulties of the block and all its ancestors
+type BlockMeta struct {
+	Hash            Hash
+	Parent          Hash
+	Number          uint64
+	Time            uint64
+	Difficulty      uint64
+	TotalDifficulty uint64
+}
+
+// Persistence backend of the chain database. Stores every known block,
+// canonical and side branches, the canonical chain as height -> hash,
+// the canonical TXs and accounts indexes and arbitrary state records such as balances snapshots
+type Storage interface {
+	PutBlock(hash Hash, b Block) error
+	GetBlock(hash Hash) (Block, error)
+	GetBlockMeta(hash Hash) (BlockMeta, bool)
+	// Removes a side branch block, e.g. found invalid when reorganizing onto it
+	DeleteBlock(hash Hash) error
+
+	// Replaces the canonical chain from the given height onwards
+	SetCanonical(fromHeight uint64, hashes []Hash) error
+	GetCanonicalHash(height uint64) (Hash, bool)
+	// Number of blocks in the canonical chain
+	Height() uint64
+	// Walks the canonical chain in ascending order starting at the given height
+	Iterate(fromHeight uint64, fn func(hash Hash, b Block) error) error
+
+	// Maps TX hashes to their blocks in the canonical chain
+	PutTxLocations(locations map[Hash]TxLocation) error
+	DeleteTxLocations(txHashes []Hash) error
+	GetTxLocation(txHash Hash) (TxLocation, bool)
+	// Maps accounts to the hashes of the canonical TXs they sent or received
+	PutAccountTXs(account2txs map[common.Address][]Hash) error
+	DeleteAccountTXs(account2txs map[common.Address][]Hash) error
+	GetAccountTXs(account common.Address) ([]Hash, error)
+
+	PutState(key string, value []byte) error
+	GetState(key string) ([]byte, bool, error)
+
+	Close() error
+}
+
+// Prepares the data dir to use the given storage backend.
+// An empty storage type keeps the backend the data dir already uses
+func InitStorage(dataDir string, storage string) error {
+	current := detectStorage(dataDir)
+
+	switch storage {
+	case "":
+		return nil
+	case StorageFile, StorageLevelDB:
+		if current == storage {
+			return nil
+		}
+
+		isEmpty, err := isStorageEmpty(dataDir)
+		if err != nil {
+			return err
+		}
+
+		if !isEmpty {
+			return fmt.Errorf("data dir already uses the '%s' storage", current)
+		}
+
+		if storage == StorageLevelDB {
+			db, err := openLevelDBStorage(dataDir, false)
+			if err != nil {
+				return err
+			}
+
+			return db.Close()
+		}
+
+		return os.RemoveAll(getLevelDBDirPath(dataDir))
+	default:
+		return fmt.Errorf("unknown storage '%s', use '%s' or '%s'", storage, StorageFile, StorageLevelDB)
+	}
+}
+
+// Opens the storage backend the data dir uses, a read-only storage
+// is meant for reading the data dir of a running node
+func openStorage(dataDir string, readOnly bool) (Storage, error) {
+	if detectStorage(dataDir) == StorageLevelDB {
+		return openLevelDBStorage(dataDir, readOnly)
+	}
+
+	return openFileStorage(dataDir, readOnly)
+}
+
+func detectStorage(dataDir string) string {
+	if fileExist(getLevelDBDirPath(dataDir)) {
+		return StorageLevelDB
+	}
+
+	return StorageFile
+}
+
+func isStorageEmpty(dataDir string) (bool, error) {
+	if detectStorage(dataDir) == StorageFile && !fileExist(getBlocksDbFilePath(dataDir)) {
+		return true, nil
+	}
+
+	store, err := openStorage(dataDir, false)
+	if err != nil {
+		return false, err
+	}
+	defer store.Close()
+
+	return store.Height() == 0, nil
+}
+
+func newBlockMeta(store Storage, hash Hash, b Block) BlockMeta {
+	totalDifficulty := b.Header.Difficulty
+	if parent, isKnown := store.GetBlockMeta(b.Header.Parent); isKnown {
+		totalDifficulty += parent.TotalDifficulty
+	}
+
+	return BlockMeta{hash, b.Header.Parent, b.Header.Number, b.Header.Time, b.Header.Difficulty, totalDifficulty}
+}
+
+func isCanonical(store Storage, hash Hash) bool {
+	meta, isKnown := store.GetBlockMeta(hash)
+	if !isKnown {
+		return false
+	}
+
+	canonicalHash, ok := store.GetCanonicalHash(meta.Number)
+
+	return ok && canonicalHash == hash
+}
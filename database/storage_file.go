@@ -0,0 +1,828 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Size of a block.idx record: hash, parent, number, time, difficulty, total difficulty, offset, length.
+// A record of length 0 removes the block
+const blockIndexRecordSize = 32 + 32 + 8 + 8 + 8 + 8 + 8 + 8
+
+// Size of a height.idx record: canonical block hash
+const heightIndexRecordSize = 32
+
+// Size of a tx.idx record: TX hash and its location, an empty block hash removes the TX
+const txIndexRecordSize = 32 + txLocationRecordSize
+
+// Size of an account.idx record: account, TX hash and whether the TX is added or removed
+const accountIndexRecordSize = 20 + 32 + 1
+
+// Location and tree position of a block persisted in block.db
+type blockIndex struct {
+	BlockMeta
+	Offset int64
+	Length int64
+}
+
+// File storage: an append-only JSON lines block.db indexed by fixed-size record files:
+// block.idx maps block hashes to their block.db offsets,
+// height.idx maps canonical chain heights to block hashes,
+// tx.idx and account.idx are append-only logs of the canonical TXs
+// locations and of the TXs each account sent or received.
+// A read-only storage ignores the records a running node is still appending.
+// The in-memory indexes are guarded by mu as HTTP handlers read them while blocks get imported
+type fileStorage struct {
+	mu           sync.RWMutex
+	dbFile       *os.File
+	indexFile    *os.File
+	heightsFile  *os.File
+	txsFile      *os.File
+	accountsFile *os.File
+	dbSize       int64
+	indexSize    int64
+	txsSize      int64
+	accountsSize int64
+	index        map[Hash]blockIndex
+	canonical    []Hash
+	txs          map[Hash]TxLocation
+	accounts     map[common.Address]map[Hash]bool
+	stateDir     string
+	readOnly     bool
+}
+
+// Opens the file storage of the data dir. A read-only storage neither locks nor rebuilds
+// the indexes so it can be read while a running node writes to it
+func openFileStorage(dataDir string, readOnly bool) (*fileStorage, error) {
+	dbFlag := os.O_APPEND | os.O_RDWR
+	indexFlag := os.O_CREATE | os.O_RDWR
+	if readOnly {
+		dbFlag = os.O_RDONLY
+		indexFlag = os.O_RDONLY
+	}
+
+	dbFile, err := os.OpenFile(getBlocksDbFilePath(dataDir), dbFlag, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files opened so far, closed again when opening the storage fails
+	files := []*os.File{dbFile}
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	// Opening the storage may rebuild the indexes, a data dir used by a running node must be left alone
+	if !readOnly {
+		err = lockFile(dbFile)
+		if err != nil {
+			closeFiles()
+			return nil, fmt.Errorf("data dir '%s' is in use by another process, e.g. a running node. %s", dataDir, err)
+		}
+	}
+
+	isTxIndexed := fileExist(getTxsIndexFilePath(dataDir)) && fileExist(getAccountsIndexFilePath(dataDir))
+
+	// A read-only storage reads the index files a node never created as empty
+	openIndexFile := func(path string) (*os.File, error) {
+		f, err := os.OpenFile(path, indexFlag, 0600)
+		if readOnly && os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			closeFiles()
+			return nil, err
+		}
+
+		files = append(files, f)
+
+		return f, nil
+	}
+
+	indexFile, err := openIndexFile(getBlocksIndexFilePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	heightsFile, err := openIndexFile(getHeightsIndexFilePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	txsFile, err := openIndexFile(getTxsIndexFilePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	accountsFile, err := openIndexFile(getAccountsIndexFilePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	store := &fileStorage{
+		dbFile:       dbFile,
+		indexFile:    indexFile,
+		heightsFile:  heightsFile,
+		txsFile:      txsFile,
+		accountsFile: accountsFile,
+		index:        make(map[Hash]blockIndex),
+		canonical:    make([]Hash, 0),
+		txs:          make(map[Hash]TxLocation),
+		accounts:     make(map[common.Address]map[Hash]bool),
+		stateDir:     getStateDirPath(dataDir),
+		readOnly:     readOnly,
+	}
+
+	err = store.loadIndex()
+	if err != nil && readOnly {
+		store.Close()
+		return nil, fmt.Errorf("block index of data dir '%s' is out of date, run the node on it once to rebuild it. %s", dataDir, err)
+	}
+	if err != nil {
+		fmt.Printf("Block index is out of date, rebuilding it from block.db. %s\n", err)
+
+		err = store.rebuildIndex()
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+
+		isTxIndexed = false
+	}
+
+	if readOnly && !isTxIndexed && len(store.canonical) > 0 {
+		store.Close()
+		return nil, fmt.Errorf("data dir '%s' has no TX index, run the node on it once to build it", dataDir)
+	}
+
+	if isTxIndexed {
+		err = store.loadTxIndex()
+		if err != nil && readOnly {
+			store.Close()
+			return nil, fmt.Errorf("TX index of data dir '%s' is corrupted, run the node on it once to rebuild it. %s", dataDir, err)
+		}
+		if err != nil {
+			fmt.Printf("TX index is corrupted, rebuilding it. %s\n", err)
+			isTxIndexed = false
+		}
+	}
+
+	if !isTxIndexed && !readOnly {
+		err = store.rebuildTxIndex()
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// Loads both index files and verifies they cover the whole block.db
+func (s *fileStorage) loadIndex() error {
+	dbInfo, err := s.dbFile.Stat()
+	if err != nil {
+		return err
+	}
+	s.dbSize = dbInfo.Size()
+
+	indexContent, err := readAll(s.indexFile)
+	if err != nil {
+		return err
+	}
+
+	// A running node may be halfway through appending a record
+	if s.readOnly {
+		indexContent = indexContent[:len(indexContent)-len(indexContent)%blockIndexRecordSize]
+	}
+
+	if len(indexContent)%blockIndexRecordSize != 0 {
+		return fmt.Errorf("block index is corrupted")
+	}
+
+	indexedSize := int64(0)
+	for i := 0; i < len(indexContent); i += blockIndexRecordSize {
+		idx := decodeBlockIndex(indexContent[i : i+blockIndexRecordSize])
+		if idx.Length == 0 {
+			delete(s.index, idx.Hash)
+		} else {
+			s.index[idx.Hash] = idx
+		}
+		indexedSize = idx.Offset + idx.Length
+	}
+	s.indexSize = int64(len(indexContent))
+
+	// A running node appends the block to block.db before indexing it
+	if s.readOnly && indexedSize < s.dbSize {
+		err = s.checkUnindexedTail(indexedSize)
+		if err != nil {
+			return err
+		}
+	} else if indexedSize != s.dbSize {
+		return fmt.Errorf("block index covers %d bytes of %d", indexedSize, s.dbSize)
+	}
+
+	heightsContent, err := readAll(s.heightsFile)
+	if err != nil {
+		return err
+	}
+
+	if s.readOnly {
+		heightsContent = heightsContent[:len(heightsContent)-len(heightsContent)%heightIndexRecordSize]
+	}
+
+	if len(heightsContent)%heightIndexRecordSize != 0 {
+		return fmt.Errorf("height index is corrupted")
+	}
+
+	for i := 0; i < len(heightsContent); i += heightIndexRecordSize {
+		var hash Hash
+		copy(hash[:], heightsContent[i:i+heightIndexRecordSize])
+
+		// The canonical chain of a running node ends where it is being rewritten
+		idx, isKnown := s.index[hash]
+		if s.readOnly && (!isKnown || idx.Number != uint64(len(s.canonical))) {
+			break
+		}
+		if !isKnown || idx.Number != uint64(len(s.canonical)) {
+			return fmt.Errorf("height index references unknown block '%s'", hash.Hex())
+		}
+
+		s.canonical = append(s.canonical, hash)
+	}
+
+	if len(s.index) > 0 && len(s.canonical) == 0 && !s.readOnly {
+		return fmt.Errorf("height index is empty")
+	}
+
+	return nil
+}
+
+// Verifies the block.db bytes past the indexed blocks are at most the one block a running node is writing
+func (s *fileStorage) checkUnindexedTail(indexedSize int64) error {
+	tail := make([]byte, s.dbSize-indexedSize)
+	_, err := s.dbFile.ReadAt(tail, indexedSize)
+	if err != nil {
+		return err
+	}
+
+	if lines := bytes.Count(tail, []byte{'\n'}); lines > 1 || (lines == 1 && tail[len(tail)-1] != '\n') {
+		return fmt.Errorf("block index covers %d bytes of %d", indexedSize, s.dbSize)
+	}
+
+	return nil
+}
+
+// Re-creates both index files by scanning block.db. The canonical chain is
+// the heaviest one, the first stored block wins on equal total difficulties
+func (s *fileStorage) rebuildIndex() error {
+	s.index = make(map[Hash]blockIndex)
+	s.canonical = make([]Hash, 0)
+	s.indexSize = 0
+
+	err := s.indexFile.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.dbFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(s.dbFile)
+	offset := int64(0)
+	var head blockIndex
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var blockFs BlockFS
+		err = json.Unmarshal(line, &blockFs)
+		if err != nil {
+			return err
+		}
+
+		// Deleted block
+		if blockFs.Key.IsEmpty() {
+			offset += int64(len(line))
+			continue
+		}
+
+		idx := blockIndex{newBlockMeta(s, blockFs.Key, blockFs.Value), offset, int64(len(line))}
+		err = s.appendIndex(idx)
+		if err != nil {
+			return err
+		}
+
+		if head.Hash.IsEmpty() || idx.TotalDifficulty > head.TotalDifficulty {
+			head = idx
+		}
+
+		offset += idx.Length
+	}
+	s.dbSize = offset
+
+	if len(s.index) == 0 {
+		return s.heightsFile.Truncate(0)
+	}
+
+	canonical := make([]Hash, head.Number+1)
+	for hash := head.Hash; !hash.IsEmpty(); hash = s.index[hash].Parent {
+		canonical[s.index[hash].Number] = hash
+	}
+
+	return s.SetCanonical(0, canonical)
+}
+
+func (s *fileStorage) loadTxIndex() error {
+	content, err := readAll(s.txsFile)
+	if err != nil {
+		return err
+	}
+
+	if s.readOnly {
+		content = content[:len(content)-len(content)%txIndexRecordSize]
+	}
+
+	if len(content)%txIndexRecordSize != 0 {
+		return fmt.Errorf("TX index size %d is not a multiple of %d", len(content), txIndexRecordSize)
+	}
+
+	for i := 0; i < len(content); i += txIndexRecordSize {
+		var txHash Hash
+		copy(txHash[:], content[i:i+32])
+		location := decodeTxLocation(content[i+32 : i+txIndexRecordSize])
+
+		if location.BlockHash.IsEmpty() {
+			delete(s.txs, txHash)
+		} else {
+			s.txs[txHash] = location
+		}
+	}
+	s.txsSize = int64(len(content))
+
+	content, err = readAll(s.accountsFile)
+	if err != nil {
+		return err
+	}
+
+	if s.readOnly {
+		content = content[:len(content)-len(content)%accountIndexRecordSize]
+	}
+
+	if len(content)%accountIndexRecordSize != 0 {
+		return fmt.Errorf("account index size %d is not a multiple of %d", len(content), accountIndexRecordSize)
+	}
+
+	for i := 0; i < len(content); i += accountIndexRecordSize {
+		account := common.BytesToAddress(content[i : i+20])
+		var txHash Hash
+		copy(txHash[:], content[i+20:i+52])
+
+		if content[i+52] == 1 {
+			s.addAccountTx(account, txHash)
+		} else {
+			delete(s.accounts[account], txHash)
+		}
+	}
+	s.accountsSize = int64(len(content))
+
+	return nil
+}
+
+func (s *fileStorage) rebuildTxIndex() error {
+	s.txs = make(map[Hash]TxLocation)
+	s.txsSize = 0
+	s.accounts = make(map[common.Address]map[Hash]bool)
+	s.accountsSize = 0
+
+	err := s.txsFile.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	err = s.accountsFile.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	return rebuildTxIndex(s)
+}
+
+func (s *fileStorage) PutBlock(hash Hash, b Block) error {
+	blockFsJson, err := json.Marshal(BlockFS{hash, b})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPersisting new block to disk:\n")
+	fmt.Printf("\t%s\n", blockFsJson)
+
+	meta := newBlockMeta(s, hash, b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := append(blockFsJson, '\n')
+	_, err = s.dbFile.Write(line)
+	if err != nil {
+		return err
+	}
+
+	idx := blockIndex{meta, s.dbSize, int64(len(line))}
+	s.dbSize += idx.Length
+
+	return s.appendIndex(idx)
+}
+
+func (s *fileStorage) appendIndex(idx blockIndex) error {
+	_, err := s.indexFile.WriteAt(encodeBlockIndex(idx), s.indexSize)
+	if err != nil {
+		return err
+	}
+
+	s.index[idx.Hash] = idx
+	s.indexSize += blockIndexRecordSize
+
+	return nil
+}
+
+// Blanks the block's line in block.db so a rebuilt index doesn't restore it, then indexes its removal
+func (s *fileStorage) DeleteBlock(hash Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, isKnown := s.index[hash]
+	if !isKnown {
+		return nil
+	}
+
+	// block.db is opened for appending only, the line is overwritten through another handle
+	dbFile, err := os.OpenFile(s.dbFile.Name(), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer dbFile.Close()
+
+	blank := append([]byte("{}"), bytes.Repeat([]byte{' '}, int(idx.Length)-3)...)
+	_, err = dbFile.WriteAt(append(blank, '\n'), idx.Offset)
+	if err != nil {
+		return err
+	}
+
+	err = s.appendIndex(blockIndex{BlockMeta{Hash: hash}, s.dbSize, 0})
+	if err != nil {
+		return err
+	}
+
+	delete(s.index, hash)
+
+	return nil
+}
+
+func (s *fileStorage) GetBlock(hash Hash) (Block, error) {
+	s.mu.RLock()
+	idx, isKnown := s.index[hash]
+	s.mu.RUnlock()
+
+	if !isKnown {
+		return Block{}, fmt.Errorf("block '%s' not found", hash.Hex())
+	}
+
+	line := make([]byte, idx.Length)
+	_, err := s.dbFile.ReadAt(line, idx.Offset)
+	if err != nil {
+		return Block{}, err
+	}
+
+	var blockFs BlockFS
+	err = json.Unmarshal(line, &blockFs)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return blockFs.Value, nil
+}
+
+func (s *fileStorage) GetBlockMeta(hash Hash) (BlockMeta, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, isKnown := s.index[hash]
+	return idx.BlockMeta, isKnown
+}
+
+// Replaces the canonical chain from the given height onwards
+func (s *fileStorage) SetCanonical(fromHeight uint64, hashes []Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.heightsFile.Truncate(int64(fromHeight) * heightIndexRecordSize)
+	if err != nil {
+		return err
+	}
+
+	records := make([]byte, 0, len(hashes)*heightIndexRecordSize)
+	for _, hash := range hashes {
+		records = append(records, hash[:]...)
+	}
+
+	_, err = s.heightsFile.WriteAt(records, int64(fromHeight)*heightIndexRecordSize)
+	if err != nil {
+		return err
+	}
+
+	s.canonical = append(s.canonical[:fromHeight], hashes...)
+
+	return nil
+}
+
+func (s *fileStorage) GetCanonicalHash(height uint64) (Hash, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if height >= uint64(len(s.canonical)) {
+		return Hash{}, false
+	}
+
+	return s.canonical[height], true
+}
+
+func (s *fileStorage) Height() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return uint64(len(s.canonical))
+}
+
+// The storage isn't locked while fn runs, fn may write to it
+func (s *fileStorage) Iterate(fromHeight uint64, fn func(hash Hash, b Block) error) error {
+	for height := fromHeight; ; height++ {
+		hash, isCanonical := s.GetCanonicalHash(height)
+		if !isCanonical {
+			return nil
+		}
+
+		b, err := s.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+
+		err = fn(hash, b)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fileStorage) PutTxLocations(locations map[Hash]TxLocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]byte, 0, len(locations)*txIndexRecordSize)
+	for txHash, location := range locations {
+		records = append(records, txHash[:]...)
+		records = append(records, encodeTxLocation(location)...)
+	}
+
+	err := s.appendTxIndex(records)
+	if err != nil {
+		return err
+	}
+
+	for txHash, location := range locations {
+		s.txs[txHash] = location
+	}
+
+	return nil
+}
+
+func (s *fileStorage) DeleteTxLocations(txHashes []Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]byte, 0, len(txHashes)*txIndexRecordSize)
+	for _, txHash := range txHashes {
+		records = append(records, txHash[:]...)
+		records = append(records, encodeTxLocation(TxLocation{})...)
+	}
+
+	err := s.appendTxIndex(records)
+	if err != nil {
+		return err
+	}
+
+	for _, txHash := range txHashes {
+		delete(s.txs, txHash)
+	}
+
+	return nil
+}
+
+func (s *fileStorage) appendTxIndex(records []byte) error {
+	_, err := s.txsFile.WriteAt(records, s.txsSize)
+	if err != nil {
+		return err
+	}
+
+	s.txsSize += int64(len(records))
+
+	return nil
+}
+
+func (s *fileStorage) GetTxLocation(txHash Hash) (TxLocation, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, isKnown := s.txs[txHash]
+	return location, isKnown
+}
+
+func (s *fileStorage) PutAccountTXs(account2txs map[common.Address][]Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.appendAccountIndex(account2txs, true)
+	if err != nil {
+		return err
+	}
+
+	for account, txHashes := range account2txs {
+		for _, txHash := range txHashes {
+			s.addAccountTx(account, txHash)
+		}
+	}
+
+	return nil
+}
+
+func (s *fileStorage) DeleteAccountTXs(account2txs map[common.Address][]Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.appendAccountIndex(account2txs, false)
+	if err != nil {
+		return err
+	}
+
+	for account, txHashes := range account2txs {
+		for _, txHash := range txHashes {
+			delete(s.accounts[account], txHash)
+		}
+	}
+
+	return nil
+}
+
+func (s *fileStorage) appendAccountIndex(account2txs map[common.Address][]Hash, isAdded bool) error {
+	records := make([]byte, 0)
+	for account, txHashes := range account2txs {
+		for _, txHash := range txHashes {
+			records = append(records, account.Bytes()...)
+			records = append(records, txHash[:]...)
+
+			if isAdded {
+				records = append(records, 1)
+			} else {
+				records = append(records, 0)
+			}
+		}
+	}
+
+	_, err := s.accountsFile.WriteAt(records, s.accountsSize)
+	if err != nil {
+		return err
+	}
+
+	s.accountsSize += int64(len(records))
+
+	return nil
+}
+
+func (s *fileStorage) addAccountTx(account common.Address, txHash Hash) {
+	if _, ok := s.accounts[account]; !ok {
+		s.accounts[account] = make(map[Hash]bool)
+	}
+
+	s.accounts[account][txHash] = true
+}
+
+func (s *fileStorage) GetAccountTXs(account common.Address) ([]Hash, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	txHashes := make([]Hash, 0, len(s.accounts[account]))
+	for txHash := range s.accounts[account] {
+		txHashes = append(txHashes, txHash)
+	}
+
+	return txHashes, nil
+}
+
+func (s *fileStorage) PutState(key string, value []byte) error {
+	if s.readOnly {
+		return fmt.Errorf("storage is opened read-only")
+	}
+
+	err := os.MkdirAll(s.stateDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// Write into a temporary file first so a crash never leaves a half written record
+	tmpPath := filepath.Join(s.stateDir, key+".tmp")
+	err = ioutil.WriteFile(tmpPath, value, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(s.stateDir, key))
+}
+
+func (s *fileStorage) GetState(key string) ([]byte, bool, error) {
+	value, err := ioutil.ReadFile(filepath.Join(s.stateDir, key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *fileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A read-only storage may miss some index files
+	for _, f := range []*os.File{s.indexFile, s.heightsFile, s.txsFile, s.accountsFile} {
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	return s.dbFile.Close()
+}
+
+func encodeBlockIndex(idx blockIndex) []byte {
+	record := make([]byte, 0, blockIndexRecordSize)
+	record = append(record, idx.Hash[:]...)
+	record = append(record, idx.Parent[:]...)
+
+	numbers := make([]byte, 48)
+	binary.BigEndian.PutUint64(numbers[0:8], idx.Number)
+	binary.BigEndian.PutUint64(numbers[8:16], idx.Time)
+	binary.BigEndian.PutUint64(numbers[16:24], idx.Difficulty)
+	binary.BigEndian.PutUint64(numbers[24:32], idx.TotalDifficulty)
+	binary.BigEndian.PutUint64(numbers[32:40], uint64(idx.Offset))
+	binary.BigEndian.PutUint64(numbers[40:48], uint64(idx.Length))
+
+	return append(record, numbers...)
+}
+
+func decodeBlockIndex(record []byte) blockIndex {
+	var idx blockIndex
+	copy(idx.Hash[:], record[0:32])
+	copy(idx.Parent[:], record[32:64])
+	idx.Number = binary.BigEndian.Uint64(record[64:72])
+	idx.Time = binary.BigEndian.Uint64(record[72:80])
+	idx.Difficulty = binary.BigEndian.Uint64(record[80:88])
+	idx.TotalDifficulty = binary.BigEndian.Uint64(record[88:96])
+	idx.Offset = int64(binary.BigEndian.Uint64(record[96:104]))
+	idx.Length = int64(binary.BigEndian.Uint64(record[104:112]))
+
+	return idx
+}
+
+func readAll(f *os.File) ([]byte, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key prefixes of the LevelDB records
+var levelDBBlockPrefix = []byte("b")
+var levelDBMetaPrefix = []byte("m")
+var levelDBCanonicalPrefix = []byte("c")
+var levelDBStatePrefix = []byte("s")
+var levelDBTxPrefix = []byte("t")
+var levelDBAccountPrefix = []byte("a")
+var levelDBHeightKey = []byte("height")
+
+// Holds the version of the TXs indexes built from the canonical chain,
+// the indexes are rebuilt when it doesn't match levelDBTxIndexVersion
+var levelDBTxIndexKey = []byte("txindex")
+
+const levelDBTxIndexVersion = 2
+
+// LevelDB storage: an embedded, pure Go LSM tree key-value store.
+// LevelDB is safe for concurrent use, mu only guards the cached height
+type levelDBStorage struct {
+	db     *leveldb.DB
+	mu     sync.RWMutex
+	height uint64
+}
+
+// Opens the LevelDB storage of the data dir. LevelDB locks its directory even
+// when opened read-only, a read-only storage can't be opened while a node runs
+func openLevelDBStorage(dataDir string, readOnly bool) (*levelDBStorage, error) {
+	db, err := leveldb.OpenFile(getLevelDBDirPath(dataDir), &opt.Options{ReadOnly: readOnly})
+	if err != nil && readOnly {
+		return nil, fmt.Errorf("data dir '%s' can't be opened read-only, e.g. a running node locks its LevelDB storage, query the node's HTTP API instead. %s", dataDir, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	height := uint64(0)
+	heightBytes, err := db.Get(levelDBHeightKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		db.Close()
+		return nil, err
+	}
+	if err == nil {
+		height = binary.BigEndian.Uint64(heightBytes)
+	}
+
+	store := &levelDBStorage{db: db, height: height}
+
+	txIndexVersion, err := db.Get(levelDBTxIndexKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		db.Close()
+		return nil, err
+	}
+
+	isTxIndexed := len(txIndexVersion) == 1 && txIndexVersion[0] == levelDBTxIndexVersion
+	if !isTxIndexed && readOnly {
+		db.Close()
+		return nil, fmt.Errorf("TX index of data dir '%s' is out of date, run the node on it once to rebuild it", dataDir)
+	}
+
+	if !isTxIndexed {
+		err = rebuildTxIndex(store)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		err = db.Put(levelDBTxIndexKey, []byte{levelDBTxIndexVersion}, nil)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+func (s *levelDBStorage) PutBlock(hash Hash, b Block) error {
+	blockJson, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPersisting new block to disk:\n")
+	fmt.Printf("\t%s\n", blockJson)
+
+	batch := new(leveldb.Batch)
+	batch.Put(levelDBKey(levelDBBlockPrefix, hash[:]), blockJson)
+	batch.Put(levelDBKey(levelDBMetaPrefix, hash[:]), encodeBlockIndex(blockIndex{newBlockMeta(s, hash, b), 0, 0}))
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) DeleteBlock(hash Hash) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(levelDBKey(levelDBBlockPrefix, hash[:]))
+	batch.Delete(levelDBKey(levelDBMetaPrefix, hash[:]))
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) GetBlock(hash Hash) (Block, error) {
+	blockJson, err := s.db.Get(levelDBKey(levelDBBlockPrefix, hash[:]), nil)
+	if err == leveldb.ErrNotFound {
+		return Block{}, fmt.Errorf("block '%s' not found", hash.Hex())
+	}
+	if err != nil {
+		return Block{}, err
+	}
+
+	var b Block
+	err = json.Unmarshal(blockJson, &b)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return b, nil
+}
+
+func (s *levelDBStorage) GetBlockMeta(hash Hash) (BlockMeta, bool) {
+	record, err := s.db.Get(levelDBKey(levelDBMetaPrefix, hash[:]), nil)
+	if err != nil {
+		return BlockMeta{}, false
+	}
+
+	return decodeBlockIndex(record).BlockMeta, true
+}
+
+func (s *levelDBStorage) SetCanonical(fromHeight uint64, hashes []Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := new(leveldb.Batch)
+
+	for height := fromHeight; height < s.height; height++ {
+		batch.Delete(levelDBKey(levelDBCanonicalPrefix, encodeHeight(height)))
+	}
+
+	for i, hash := range hashes {
+		batch.Put(levelDBKey(levelDBCanonicalPrefix, encodeHeight(fromHeight+uint64(i))), hash[:])
+	}
+
+	height := fromHeight + uint64(len(hashes))
+	batch.Put(levelDBHeightKey, encodeHeight(height))
+
+	err := s.db.Write(batch, nil)
+	if err != nil {
+		return err
+	}
+
+	s.height = height
+
+	return nil
+}
+
+func (s *levelDBStorage) GetCanonicalHash(height uint64) (Hash, bool) {
+	if height >= s.Height() {
+		return Hash{}, false
+	}
+
+	value, err := s.db.Get(levelDBKey(levelDBCanonicalPrefix, encodeHeight(height)), nil)
+	if err != nil {
+		return Hash{}, false
+	}
+
+	var hash Hash
+	copy(hash[:], value)
+
+	return hash, true
+}
+
+func (s *levelDBStorage) Height() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.height
+}
+
+func (s *levelDBStorage) Iterate(fromHeight uint64, fn func(hash Hash, b Block) error) error {
+	for height := fromHeight; height < s.Height(); height++ {
+		hash, ok := s.GetCanonicalHash(height)
+		if !ok {
+			return fmt.Errorf("canonical block at height %d not found", height)
+		}
+
+		b, err := s.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+
+		err = fn(hash, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *levelDBStorage) PutTxLocations(locations map[Hash]TxLocation) error {
+	batch := new(leveldb.Batch)
+	for txHash, location := range locations {
+		batch.Put(levelDBKey(levelDBTxPrefix, txHash[:]), encodeTxLocation(location))
+	}
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) DeleteTxLocations(txHashes []Hash) error {
+	batch := new(leveldb.Batch)
+	for _, txHash := range txHashes {
+		batch.Delete(levelDBKey(levelDBTxPrefix, txHash[:]))
+	}
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) GetTxLocation(txHash Hash) (TxLocation, bool) {
+	record, err := s.db.Get(levelDBKey(levelDBTxPrefix, txHash[:]), nil)
+	if err != nil {
+		return TxLocation{}, false
+	}
+
+	return decodeTxLocation(record), true
+}
+
+func (s *levelDBStorage) PutAccountTXs(account2txs map[common.Address][]Hash) error {
+	batch := new(leveldb.Batch)
+	for account, txHashes := range account2txs {
+		for _, txHash := range txHashes {
+			batch.Put(levelDBKey(levelDBAccountPrefix, append(account.Bytes(), txHash[:]...)), nil)
+		}
+	}
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) DeleteAccountTXs(account2txs map[common.Address][]Hash) error {
+	batch := new(leveldb.Batch)
+	for account, txHashes := range account2txs {
+		for _, txHash := range txHashes {
+			batch.Delete(levelDBKey(levelDBAccountPrefix, append(account.Bytes(), txHash[:]...)))
+		}
+	}
+
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDBStorage) GetAccountTXs(account common.Address) ([]Hash, error) {
+	prefix := levelDBKey(levelDBAccountPrefix, account.Bytes())
+	txHashes := make([]Hash, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var txHash Hash
+		copy(txHash[:], iter.Key()[len(prefix):])
+		txHashes = append(txHashes, txHash)
+	}
+
+	return txHashes, iter.Error()
+}
+
+func (s *levelDBStorage) PutState(key string, value []byte) error {
+	return s.db.Put(levelDBKey(levelDBStatePrefix, []byte(key)), value, nil)
+}
+
+func (s *levelDBStorage) GetState(key string) ([]byte, bool, error) {
+	value, err := s.db.Get(levelDBKey(levelDBStatePrefix, []byte(key)), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *levelDBStorage) Close() error {
+	return s.db.Close()
+}
+
+func levelDBKey(prefix []byte, key []byte) []byte {
+	return append(append([]byte{}, prefix...), key...)
+}
+
+func encodeHeight(height uint64) []byte {
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+
+	return heightBytes
+}
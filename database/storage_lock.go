@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package database
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive lock on the file, released when the file is closed or the process exits
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
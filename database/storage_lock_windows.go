@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package database
+
+import (
+	"os"
+)
+
+// Not supported on Windows, a data dir must not be opened by two processes at once
+func lockFile(f *os.File) error {
+	return nil
+}
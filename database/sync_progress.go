@@ -0,0 +1,74 @@
+package database
+
+import (
+	"encoding/json"
+)
+
+const syncProgressKey = "sync_progress"
+const syncCursorKey = "sync_cursor"
+
+// Verified headers ahead of the local chain whose blocks are yet to be downloaded,
+// persisted so an interrupted sync resumes without fetching the headers again
+type SyncProgress struct {
+	Headers []BlockHeaderFS `json:"headers"`
+}
+
+// Saves the headers of a new sync once, the imported blocks only move the cursor
+func (s *State) SaveSyncProgress(progress SyncProgress) error {
+	progressJson, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.PutState(syncProgressKey, progressJson)
+	if err != nil {
+		return err
+	}
+
+	return s.SaveSyncCursor(Hash{})
+}
+
+// Records the last header whose block was imported
+func (s *State) SaveSyncCursor(cursor Hash) error {
+	cursorJson, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+
+	return s.store.PutState(syncCursorKey, cursorJson)
+}
+
+// Returns the headers following the cursor, an empty progress when no sync was interrupted
+func (s *State) LoadSyncProgress() (SyncProgress, error) {
+	progressJson, exists, err := s.store.GetState(syncProgressKey)
+	if err != nil || !exists {
+		return SyncProgress{}, err
+	}
+
+	var progress SyncProgress
+	err = json.Unmarshal(progressJson, &progress)
+	if err != nil {
+		return SyncProgress{}, err
+	}
+
+	cursorJson, exists, err := s.store.GetState(syncCursorKey)
+	if err != nil || !exists {
+		return progress, err
+	}
+
+	var cursor Hash
+	err = json.Unmarshal(cursorJson, &cursor)
+	if err != nil {
+		return SyncProgress{}, err
+	}
+
+	// A cursor left over from an earlier sync doesn't match any header
+	for i, header := range progress.Headers {
+		if header.Key == cursor {
+			progress.Headers = progress.Headers[i+1:]
+			break
+		}
+	}
+
+	return progress, nil
+}
@@ -11,12 +11,14 @@ import (
 )
 
 type Tx struct {
-	From  common.Address `json:"from"`
-	To    common.Address `json:"to"`
-	Value uint           `json:"value"`
-	Nonce uint           `json:"nonce"`
-	Data  string         `json:"data"`
-	Time  uint64         `json:"time"`
+	ChainID string         `json:"chain_id"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	Value   uint           `json:"value"`
+	Fee     uint           `json:"fee"`
+	Nonce   uint           `json:"nonce"`
+	Data    string         `json:"data"`
+	Time    uint64         `json:"time"`
 }
 
 type SignedTx struct {
@@ -28,8 +30,21 @@ func NewAccount(value string) common.Address {
 	return common.HexToAddress(value)
 }
 
+// The TX pays the DefaultMinTxFee unless set otherwise with WithFee
 func NewTx(from, to common.Address, value, nonce uint, data string) Tx {
-	return Tx{from, to, value, nonce, data, uint64(time.Now().Unix())}
+	return Tx{From: from, To: to, Value: value, Fee: DefaultMinTxFee, Nonce: nonce, Data: data, Time: uint64(time.Now().Unix())}
+}
+
+// Binds the TX to a chain so its signature can't be replayed on other networks
+func (t Tx) WithChainID(chainID string) Tx {
+	t.ChainID = chainID
+	return t
+}
+
+// A higher fee gets the TX mined sooner, the fee goes to the block's miner
+func (t Tx) WithFee(fee uint) Tx {
+	t.Fee = fee
+	return t
 }
 
 func NewSignedTx(tx Tx, sig []byte) SignedTx {
@@ -41,7 +56,7 @@ func (t Tx) IsReward() bool {
 }
 
 func (t Tx) Cost() uint {
-	return t.Value + TxFee
+	return t.Value + t.Fee
 }
 
 func (t Tx) Hash() (Hash, error) {
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Size of an encoded TX location: block hash, block number, index in the block
+const txLocationRecordSize = 32 + 8 + 8
+
+// Position of a mined TX within the canonical chain
+type TxLocation struct {
+	BlockHash   Hash   `json:"block_hash"`
+	BlockNumber uint64 `json:"block_number"`
+	Index       uint64 `json:"index"`
+}
+
+// Finds the canonical block including the TX with the given hash
+func (s *State) GetTxLocation(txHash Hash) (TxLocation, bool) {
+	location, isKnown := s.store.GetTxLocation(txHash)
+
+	// Entries of blocks abandoned by an interrupted reorg are ignored
+	if !isKnown || !isCanonical(s.store, location.BlockHash) {
+		return TxLocation{}, false
+	}
+
+	return location, true
+}
+
+func (s *State) GetTx(txHash Hash) (SignedTx, TxLocation, bool, error) {
+	location, isKnown := s.GetTxLocation(txHash)
+	if !isKnown {
+		return SignedTx{}, TxLocation{}, false, nil
+	}
+
+	b, err := s.store.GetBlock(location.BlockHash)
+	if err != nil {
+		return SignedTx{}, TxLocation{}, false, err
+	}
+
+	if location.Index >= uint64(len(b.TXs)) {
+		return SignedTx{}, TxLocation{}, false, fmt.Errorf("TX index %d is out of block '%s'", location.Index, location.BlockHash.Hex())
+	}
+
+	return b.TXs[location.Index], location, true, nil
+}
+
+func indexBlockTXs(store Storage, hash Hash, b Block) error {
+	locations := make(map[Hash]TxLocation)
+
+	for i, tx := range b.TXs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		locations[txHash] = TxLocation{hash, b.Header.Number, uint64(i)}
+	}
+
+	err := store.PutTxLocations(locations)
+	if err != nil {
+		return err
+	}
+
+	account2txs, err := accountsTXs(b.TXs)
+	if err != nil {
+		return err
+	}
+
+	return store.PutAccountTXs(account2txs)
+}
+
+func unindexTXs(store Storage, txs []SignedTx) error {
+	txHashes, err := txsHashes(txs)
+	if err != nil {
+		return err
+	}
+
+	err = store.DeleteTxLocations(txHashes)
+	if err != nil {
+		return err
+	}
+
+	account2txs, err := accountsTXs(txs)
+	if err != nil {
+		return err
+	}
+
+	return store.DeleteAccountTXs(account2txs)
+}
+
+// Re-creates the TXs and accounts indexes of a storage from its canonical chain
+func rebuildTxIndex(store Storage) error {
+	if store.Height() > 0 {
+		fmt.Printf("Indexing TXs of %d canonical blocks\n", store.Height())
+	}
+
+	return store.Iterate(0, func(hash Hash, b Block) error {
+		return indexBlockTXs(store, hash, b)
+	})
+}
+
+func encodeTxLocation(location TxLocation) []byte {
+	record := make([]byte, txLocationRecordSize)
+	copy(record[0:32], location.BlockHash[:])
+	binary.BigEndian.PutUint64(record[32:40], location.BlockNumber)
+	binary.BigEndian.PutUint64(record[40:48], location.Index)
+
+	return record
+}
+
+func decodeTxLocation(record []byte) TxLocation {
+	var location TxLocation
+	copy(location.BlockHash[:], record[0:32])
+	location.BlockNumber = binary.BigEndian.Uint64(record[32:40])
+	location.Index = binary.BigEndian.Uint64(record[40:48])
+
+	return location
+}
@@ -7,5 +7,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/ethereum/go-ethereum v1.10.3
 	github.com/google/uuid v1.1.5
+	github.com/gorilla/websocket v1.4.2
 	github.com/spf13/cobra v1.1.3
+	github.com/syndtr/goleveldb v1.0.1-0.20210305035536-64b5b1c73954
 )
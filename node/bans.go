@@ -0,0 +1,373 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+const endpointPeers = "/node/peers"
+const endpointPeersBan = "/node/peers/ban"
+const endpointPeersUnban = "/node/peers/unban"
+
+const bansFileName = "bans.json"
+
+// Score penalties for misbehaving peers, a peer is banned once its score drops to peerBanScore
+const peerPenaltyInvalidTx = 20
+const peerPenaltyInvalidBlock = 50
+const peerBanScore = -100
+const peerMaxScore = 100
+
+// Automatic bans double in length each time, a peer banned peerMaxTempBans times is banned for good
+const peerBanDuration = time.Hour
+const peerMaxTempBans = 3
+
+// Expired bans are remembered this long to escalate the next ban
+const peerBanHistory = 7 * 24 * time.Hour
+
+// Bans are keyed by the peer's IP, a banned peer can't come back on another port
+type PeerBan struct {
+	Address   string `json:"address"`
+	Reason    string `json:"reason"`
+	BannedAt  uint64 `json:"banned_at"`
+	Until     uint64 `json:"until"`
+	Permanent bool   `json:"permanent"`
+	Count     uint64 `json:"count"`
+}
+
+func (b PeerBan) IsActive(now time.Time) bool {
+	return b.Permanent || b.Until > uint64(now.Unix())
+}
+
+type PeersRes struct {
+	Peers []PeerRecord `json:"peers"`
+	Bans  []PeerBan    `json:"bans"`
+}
+
+// Bans the peer for Duration seconds, or for good if Permanent
+type PeerBanReq struct {
+	Address   string `json:"address"`
+	Duration  uint64 `json:"duration"`
+	Permanent bool   `json:"permanent"`
+	Reason    string `json:"reason"`
+}
+
+type PeerUnbanReq struct {
+	Address string `json:"address"`
+}
+
+type PeerBanRes struct {
+	Success bool `json:"success"`
+}
+
+func getBansFilePath(dataDir string) string {
+	return filepath.Join(dataDir, bansFileName)
+}
+
+func loadBans(dataDir string) ([]PeerBan, error) {
+	bansJson, err := ioutil.ReadFile(getBansFilePath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []PeerBan
+	err = json.Unmarshal(bansJson, &bans)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bans file '%s'. %s", getBansFilePath(dataDir), err)
+	}
+
+	return bans, nil
+}
+
+func (n *Node) restoreBans(bans []PeerBan) {
+	n.bansMu.Lock()
+	defer n.bansMu.Unlock()
+
+	forgottenBefore := uint64(time.Now().Add(-peerBanHistory).Unix())
+	for _, ban := range bans {
+		if !ban.Permanent && ban.Until < forgottenBefore {
+			continue
+		}
+
+		n.bans[ban.Address] = ban
+	}
+}
+
+func (n *Node) saveBans() error {
+	n.bansMu.Lock()
+	bans := make([]PeerBan, 0, len(n.bans))
+	for _, ban := range n.bans {
+		bans = append(bans, ban)
+	}
+	n.bansMu.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Address < bans[j].Address
+	})
+
+	return writeJsonFile(getBansFilePath(n.dataDir), bans)
+}
+
+// Identifies a peer's bans by the IP of its address, given with or without a port.
+// A peer announced by a hostname is banned by its hostname
+func banKey(address string) (string, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("invalid peer address '%s'", address)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host, nil
+	}
+
+	return ip.String(), nil
+}
+
+// Bans the peer's IP for the duration, or for good if the duration is 0, and forgets all the peers at the IP
+func (n *Node) BanPeer(address string, duration time.Duration, reason string) error {
+	key, err := banKey(address)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	n.bansMu.Lock()
+	ban := n.bans[key]
+	ban.Address = key
+	ban.Reason = reason
+	ban.BannedAt = uint64(now.Unix())
+	ban.Until = 0
+	ban.Permanent = duration == 0
+	if !ban.Permanent {
+		ban.Until = uint64(now.Add(duration).Unix())
+	}
+	ban.Count++
+	n.bans[key] = ban
+	n.bansMu.Unlock()
+
+	for _, peer := range n.KnownPeers() {
+		if peerKey, _ := banKey(peer.IP); peerKey == key {
+			n.RemovePeer(peer)
+		}
+	}
+
+	if ban.Permanent {
+		fmt.Printf("Peer '%s' was banned permanently. %s\n", key, reason)
+	} else {
+		fmt.Printf("Peer '%s' was banned for %s. %s\n", key, duration, reason)
+	}
+
+	return n.saveBans()
+}
+
+// Lifts the ban of the peer's IP and forgets its previous bans
+func (n *Node) UnbanPeer(address string) error {
+	key, err := banKey(address)
+	if err != nil {
+		return err
+	}
+
+	n.bansMu.Lock()
+	ban, isBanned := n.bans[key]
+	isBanned = isBanned && ban.IsActive(time.Now())
+	if isBanned {
+		delete(n.bans, key)
+	}
+	n.bansMu.Unlock()
+
+	if !isBanned {
+		return fmt.Errorf("peer '%s' is not banned", key)
+	}
+
+	fmt.Printf("Peer '%s' was unbanned\n", key)
+
+	return n.saveBans()
+}
+
+// Checks the ban of the address' IP, e.g. a known peer's TCP address or a request's remote address
+func (n *Node) IsBannedPeer(address string) bool {
+	key, err := banKey(address)
+	if err != nil {
+		return false
+	}
+
+	n.bansMu.Lock()
+	defer n.bansMu.Unlock()
+
+	ban, isBanned := n.bans[key]
+	return isBanned && ban.IsActive(time.Now())
+}
+
+// Active bans, ordered by address
+func (n *Node) PeerBans() []PeerBan {
+	n.bansMu.Lock()
+	defer n.bansMu.Unlock()
+
+	now := time.Now()
+	bans := make([]PeerBan, 0)
+	for _, ban := range n.bans {
+		if ban.IsActive(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Address < bans[j].Address
+	})
+
+	return bans
+}
+
+// Lowers the known peer's score and bans the peer once the score drops too low
+func (n *Node) penalizePeer(tcpAddress string, penalty int, reason error) {
+	if tcpAddress == n.info.TcpAddress() {
+		return
+	}
+
+	n.knownPeersMu.Lock()
+	stats, isKnownPeer := n.peerStats[tcpAddress]
+	if isKnownPeer {
+		stats.Score -= penalty
+		n.peerStats[tcpAddress] = stats
+	}
+	n.knownPeersMu.Unlock()
+
+	if !isKnownPeer {
+		return
+	}
+
+	fmt.Printf("Peer '%s' score dropped to %d. %s\n", tcpAddress, stats.Score, reason)
+
+	if stats.Score > peerBanScore {
+		return
+	}
+
+	key, err := banKey(tcpAddress)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	n.bansMu.Lock()
+	previousBans := n.bans[key].Count
+	n.bansMu.Unlock()
+
+	duration := peerBanDuration << previousBans
+	if previousBans >= peerMaxTempBans {
+		duration = 0
+	}
+
+	err = n.BanPeer(tcpAddress, duration, fmt.Sprintf("Score dropped to %d. %s", stats.Score, reason))
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+}
+
+// Adds a TX relayed by a peer, penalizing the peer for forged or invalid TXs
+func (n *Node) addPeerTX(tx database.SignedTx, peer PeerNode) error {
+	err := database.ValidateTxIntrinsic(tx, n.state.ChainParams())
+	if err != nil {
+		n.penalizePeer(peer.TcpAddress(), peerPenaltyInvalidTx, err)
+		return err
+	}
+
+	return n.AddPendingTX(tx, peer)
+}
+
+// Adds the bootstrap peers again, e.g. after their ban expired
+func (n *Node) addBootstraps() {
+	for _, bootstrap := range n.bootstraps {
+		if !n.IsKnownPeer(bootstrap) && !n.IsBannedPeer(bootstrap.TcpAddress()) {
+			n.AddPeer(bootstrap)
+		}
+	}
+}
+
+// The admin endpoints only serve requests coming from the node's own machine
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func peersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	if !isLocalRequest(r) {
+		writeErrRes(w, fmt.Errorf("peers are only listed to local requests"))
+		return
+	}
+
+	writeRes(w, PeersRes{node.PeerRecords(), node.PeerBans()})
+}
+
+func banPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	if !isLocalRequest(r) {
+		writeErrRes(w, fmt.Errorf("peers can only be banned by local requests"))
+		return
+	}
+
+	req := PeerBanReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	duration := time.Duration(req.Duration) * time.Second
+	if duration == 0 {
+		duration = peerBanDuration
+	}
+	if req.Permanent {
+		duration = 0
+	}
+
+	err = node.BanPeer(req.Address, duration, req.Reason)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, PeerBanRes{Success: true})
+}
+
+func unbanPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	if !isLocalRequest(r) {
+		writeErrRes(w, fmt.Errorf("peers can only be unbanned by local requests"))
+		return
+	}
+
+	req := PeerUnbanReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = node.UnbanPeer(req.Address)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, PeerBanRes{Success: true})
+}
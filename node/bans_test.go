@@ -0,0 +1,245 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_BanMisbehavingPeer(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{sender: 1000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	miner := database.NewAccount(DefaultMiner)
+	peer := NewPeerNode("127.0.0.1", 8083, false, miner, true)
+	n.AddPeer(peer)
+
+	tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, ""), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Forged by changing the value after signing
+	tx.Value = 1000
+	for i := 0; i < -peerBanScore/peerPenaltyInvalidTx; i++ {
+		if err = n.addPeerTX(tx, peer); err == nil {
+			t.Fatalf("forged TX must be rejected")
+		}
+	}
+
+	if !n.IsBannedPeer(peer.TcpAddress()) || n.IsKnownPeer(peer) {
+		t.Fatalf("peer relaying forged TXs must be banned and forgotten")
+	}
+
+	if !n.IsBannedPeer("127.0.0.1:8084") {
+		t.Fatalf("peer must be banned on any port of its IP")
+	}
+
+	status := StatusRes{KnownPeers: map[string]PeerNode{peer.TcpAddress(): peer}}
+	err = n.syncKnownPeers(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.IsKnownPeer(peer) {
+		t.Fatalf("banned peer listed by another peer must not be added again")
+	}
+
+	// Restarted node
+	restarted := New(dataDir, "127.0.0.1", 8085, miner)
+	if !restarted.IsBannedPeer(peer.TcpAddress()) {
+		t.Fatalf("ban must be restored after a restart")
+	}
+
+	err = n.UnbanPeer(peer.TcpAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.AddPeer(peer)
+	err = n.BanPeer(peer.TcpAddress(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bans := n.PeerBans()
+	// Unbanning forgives the previous bans
+	if len(bans) != 1 || !bans[0].Permanent || bans[0].Count != 1 {
+		t.Fatalf("peer must be banned permanently, got %+v", bans)
+	}
+}
+
+func TestNode_PeersAdminEndpoints(t *testing.T) {
+	miner := database.NewAccount(DefaultMiner)
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	peer := NewPeerNode("127.0.0.1", 8083, false, miner, true)
+	n := New(dataDir, "127.0.0.1", 8085, miner, peer)
+
+	handler := http.NewServeMux()
+	handler.HandleFunc(endpointPeers, func(w http.ResponseWriter, r *http.Request) {
+		peersHandler(w, r, n)
+	})
+	handler.HandleFunc(endpointPeersBan, func(w http.ResponseWriter, r *http.Request) {
+		banPeerHandler(w, r, n)
+	})
+	handler.HandleFunc(endpointPeersUnban, func(w http.ResponseWriter, r *http.Request) {
+		unbanPeerHandler(w, r, n)
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	post := func(endpoint string, req interface{}) error {
+		reqJson, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.Post(fmt.Sprintf("%s%s", server.URL, endpoint), "application/json", bytes.NewReader(reqJson))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return readRes(res, &PeerBanRes{})
+	}
+
+	err = post(endpointPeersBan, PeerBanReq{Address: peer.TcpAddress(), Duration: 60, Reason: "spam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s%s", server.URL, endpointPeers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peersRes := PeersRes{}
+	err = readRes(res, &peersRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peersRes.Peers) != 0 || len(peersRes.Bans) != 1 || peersRes.Bans[0].Reason != "spam" || peersRes.Bans[0].Permanent {
+		t.Fatalf("peer must be listed as temporarily banned, got %+v", peersRes)
+	}
+
+	err = post(endpointPeersUnban, PeerUnbanReq{Address: peer.TcpAddress()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.IsBannedPeer(peer.TcpAddress()) {
+		t.Fatalf("peer must be unbanned")
+	}
+
+	if err = post(endpointPeersUnban, PeerUnbanReq{Address: peer.TcpAddress()}); err == nil {
+		t.Fatalf("unbanning a peer which isn't banned must fail")
+	}
+
+	remoteReq := httptest.NewRequest(http.MethodGet, endpointPeers, nil)
+	remoteReq.RemoteAddr = "203.0.113.1:1234"
+	w := httptest.NewRecorder()
+	peersHandler(w, remoteReq, n)
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("admin endpoints must refuse remote requests")
+	}
+}
+
+func TestNode_SpoofedGossipSenderIsNotPenalized(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{sender: 1000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	peer := NewPeerNode("127.0.0.1", 8083, false, database.NewAccount(DefaultMiner), true)
+	n.AddPeer(peer)
+
+	// Every forged TX is new, seen TXs aren't processed again
+	nonce := uint(0)
+	gossipForgedTx := func(remoteAddr string) {
+		nonce++
+		tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, nonce, ""), privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Forged by changing the value after signing
+		tx.Value = 1000
+
+		reqJson, err := json.Marshal(GossipTxReq{peer, tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, endpointGossipTx, bytes.NewReader(reqJson))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		gossipTxHandler(w, req, n)
+
+		if w.Code == http.StatusOK {
+			t.Fatalf("forged TX must be rejected")
+		}
+	}
+
+	// Another host claiming to be the peer
+	for i := 0; i < -peerBanScore/peerPenaltyInvalidTx; i++ {
+		gossipForgedTx("203.0.113.1:1234")
+	}
+
+	if n.IsBannedPeer(peer.TcpAddress()) || !n.IsKnownPeer(peer) || len(n.PeerBans()) != 0 {
+		t.Fatalf("peer impersonated by another host must not be banned")
+	}
+
+	// The peer itself
+	for i := 0; i < -peerBanScore/peerPenaltyInvalidTx; i++ {
+		gossipForgedTx("127.0.0.1:50000")
+	}
+
+	if !n.IsBannedPeer(peer.TcpAddress()) {
+		t.Fatalf("peer gossiping forged TXs from its own IP must be banned")
+	}
+
+	tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, ""), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The banned peer claiming to be another peer, from another port
+	reqJson, err := json.Marshal(GossipTxReq{NewPeerNode("203.0.113.1", 8083, false, database.NewAccount(DefaultMiner), true), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, endpointGossipTx, bytes.NewReader(reqJson))
+	req.RemoteAddr = "127.0.0.1:50001"
+	w := httptest.NewRecorder()
+	gossipTxHandler(w, req, n)
+
+	if w.Code == http.StatusOK || len(n.mempool.Sorted()) != 0 {
+		t.Fatalf("banned peer must not gossip under another address")
+	}
+}
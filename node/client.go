@@ -0,0 +1,33 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+// Submits a TX signed by its sender to the node's HTTP API, e.g. "http://localhost:8080"
+func SendRawTx(nodeUrl string, tx database.SignedTx) (database.Hash, error) {
+	txJson, err := json.Marshal(tx)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	url := fmt.Sprintf("%s%s", strings.TrimSuffix(nodeUrl, "/"), endpointTxSendRaw)
+	res, err := http.Post(url, "application/json", bytes.NewReader(txJson))
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	sendRawRes := TxSendRawRes{}
+	err = readRes(res, &sendRawRes)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return sendRawRes.Hash, nil
+}
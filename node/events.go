@@ -0,0 +1,79 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+const EventNewHead = "new_head"
+const EventPendingTx = "pending_tx"
+
+// Size of each subscriber's queue, events are dropped for subscribers not keeping up
+const eventSubscriberBuffer = 256
+
+// A change of the node's chain or Mempool. New head events carry the block
+// which became the latest one, pending TX events the TX added into the Mempool
+type Event struct {
+	Type  string             `json:"type"`
+	Hash  database.Hash      `json:"hash"`
+	Block *database.Block    `json:"block,omitempty"`
+	Tx    *database.SignedTx `json:"tx,omitempty"`
+}
+
+// Fans out the node's events to every subscriber
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[int]chan Event
+	nextID      int
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[int]chan Event)}
+}
+
+func (b *eventBus) Subscribe() (int, <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+
+	events := make(chan Event, eventSubscriberBuffer)
+	b.subscribers[id] = events
+
+	return id, events
+}
+
+func (b *eventBus) Unsubscribe(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if events, ok := b.subscribers[id]; ok {
+		close(events)
+		delete(b.subscribers, id)
+	}
+}
+
+// Never blocks, the publishers are the mining and syncing loops
+func (b *eventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, events := range b.subscribers {
+		select {
+		case events <- e:
+		default:
+			fmt.Printf("Event subscriber %d is too slow, dropping '%s' event\n", id, e.Type)
+		}
+	}
+}
+
+func newHeadEvent(hash database.Hash, block database.Block) Event {
+	return Event{Type: EventNewHead, Hash: hash, Block: &block}
+}
+
+func newPendingTxEvent(hash database.Hash, tx database.SignedTx) Event {
+	return Event{Type: EventPendingTx, Hash: hash, Tx: &tx}
+}
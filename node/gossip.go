@@ -0,0 +1,292 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+const endpointGossipBlock = "/node/gossip/block"
+const endpointGossipTx = "/node/gossip/tx"
+
+const gossipTimeout = 5 * time.Second
+
+// Recently announced blocks and TXs remembered to avoid processing and announcing them twice
+const gossipSeenHashesSize = 10000
+
+// Gossiped blocks waiting to be imported, the periodic sync catches up on those dropped
+const gossipedBlocksBuffer = 64
+
+var gossipClient = &http.Client{Timeout: gossipTimeout}
+
+type GossipBlockReq struct {
+	From  PeerNode       `json:"from"`
+	Block database.Block `json:"block"`
+}
+
+type GossipTxReq struct {
+	From PeerNode          `json:"from"`
+	Tx   database.SignedTx `json:"tx"`
+}
+
+// Known is true when the node had already seen the announced block or TX
+type GossipRes struct {
+	Known bool `json:"known"`
+}
+
+// Bounded set of seen hashes with the address of the peer each one came from
+type seenHashes struct {
+	mu      sync.Mutex
+	maxSize int
+	origins map[database.Hash]string
+	order   []database.Hash
+}
+
+func newSeenHashes(maxSize int) *seenHashes {
+	return &seenHashes{maxSize: maxSize, origins: make(map[database.Hash]string)}
+}
+
+// Remembers the hash and reports whether it wasn't seen before, the oldest hash is forgotten when full
+func (s *seenHashes) Add(hash database.Hash, origin string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, isSeen := s.origins[hash]; isSeen {
+		return false
+	}
+
+	if len(s.order) >= s.maxSize {
+		delete(s.origins, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	s.origins[hash] = origin
+	s.order = append(s.order, hash)
+
+	return true
+}
+
+func (s *seenHashes) Origin(hash database.Hash) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	origin, isSeen := s.origins[hash]
+	return origin, isSeen
+}
+
+// Announces every new head and pending TX to the known peers as soon as it's published
+func (n *Node) gossip(ctx context.Context) {
+	subID, events := n.events.Subscribe()
+	defer n.events.Unsubscribe(subID)
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+
+			n.announce(e)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Pushes the event's block or TX to all known peers but the one it came from.
+// A catch-up sync imports many heads in a row, heads already replaced or imported
+// during a sync aren't announced, the sync announces the head it ends on instead
+func (n *Node) announce(e Event) {
+	var endpoint string
+	var req interface{}
+
+	switch e.Type {
+	case EventNewHead:
+		if n.syncTracker.isSyncing() || e.Hash != n.state.LatestBlockHash() || n.isAnnouncedByNode(e.Hash) {
+			return
+		}
+
+		endpoint = endpointGossipBlock
+		req = GossipBlockReq{n.info, *e.Block}
+	case EventPendingTx:
+		endpoint = endpointGossipTx
+		req = GossipTxReq{n.info, *e.Tx}
+	default:
+		return
+	}
+
+	n.gossipSeen.Add(e.Hash, n.info.TcpAddress())
+	origin, _ := n.gossipSeen.Origin(e.Hash)
+
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range n.KnownPeers() {
+		if peer.IP == "" || peer.TcpAddress() == origin || peer.TcpAddress() == n.info.TcpAddress() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(peer PeerNode) {
+			defer wg.Done()
+
+			err := pushToPeer(peer, endpoint, reqJson)
+			if err != nil {
+				fmt.Printf("Announcing %s '%s' to peer %s failed. %s\n", e.Type, e.Hash.Hex(), peer.TcpAddress(), err)
+			}
+		}(peer)
+	}
+
+	wg.Wait()
+}
+
+func (n *Node) isAnnouncedByNode(hash database.Hash) bool {
+	origin, isSeen := n.gossipSeen.Origin(hash)
+	return isSeen && origin == n.info.TcpAddress()
+}
+
+func pushToPeer(peer PeerNode, endpoint string, reqJson []byte) error {
+	url := fmt.Sprintf("%s://%s%s", peer.ApiProtocol(), peer.TcpAddress(), endpoint)
+
+	res, err := gossipClient.Post(url, "application/json", bytes.NewReader(reqJson))
+	if err != nil {
+		return err
+	}
+
+	return readRes(res, &GossipRes{})
+}
+
+// Imports a block announced by a peer, a block with an unknown parent triggers a sync to catch up
+func (n *Node) importGossipedBlock(block database.Block) {
+	blockHash, err := block.Hash()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+
+	if n.state.HasBlock(blockHash) {
+		return
+	}
+
+	if !block.Header.Parent.IsEmpty() && !n.state.HasBlock(block.Header.Parent) {
+		fmt.Printf("Gossiped block %s has an unknown parent, syncing with peers\n", blockHash.Hex())
+		n.doSync()
+		return
+	}
+
+	err = n.addBlock(block)
+	if err != nil {
+		fmt.Printf("Gossiped block %s rejected. %s\n", blockHash.Hex(), err)
+
+		if origin, isSeen := n.gossipSeen.Origin(blockHash); isSeen {
+			n.penalizePeer(origin, peerPenaltyInvalidBlock, err)
+		}
+		return
+	}
+
+	// Side branch blocks don't replace the block being mined on
+	if n.state.LatestBlockHash() == blockHash {
+		n.newSyncedBlocks <- block
+	}
+}
+
+func gossipBlockHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := GossipBlockReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if node.IsBannedPeer(r.RemoteAddr) {
+		writeErrRes(w, fmt.Errorf("peer '%s' is banned", r.RemoteAddr))
+		return
+	}
+
+	sender := gossipSender(r, req.From)
+
+	blockHash, err := req.Block.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if !node.gossipSeen.Add(blockHash, sender.TcpAddress()) {
+		writeRes(w, GossipRes{Known: true})
+		return
+	}
+
+	// Imported by the sync loop, one block at a time
+	select {
+	case node.gossipedBlocks <- req.Block:
+	default:
+		fmt.Printf("Too many gossiped blocks to import, dropping block %s\n", blockHash.Hex())
+	}
+
+	writeRes(w, GossipRes{Known: false})
+}
+
+func gossipTxHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := GossipTxReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if node.IsBannedPeer(r.RemoteAddr) {
+		writeErrRes(w, fmt.Errorf("peer '%s' is banned", r.RemoteAddr))
+		return
+	}
+
+	sender := gossipSender(r, req.From)
+
+	txHash, err := req.Tx.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if !node.gossipSeen.Add(txHash, sender.TcpAddress()) {
+		writeRes(w, GossipRes{Known: true})
+		return
+	}
+
+	err = node.addPeerTX(req.Tx, sender)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, GossipRes{Known: false})
+}
+
+// Identifies the peer a gossip request comes from. The announced From peer is only trusted
+// when the request comes from its IP, otherwise anyone could get any peer penalized and banned.
+// An unverified sender is identified by its IP alone, it's never a known peer to penalize
+func gossipSender(r *http.Request, from PeerNode) PeerNode {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return PeerNode{}
+	}
+
+	remoteIP := net.ParseIP(host)
+	fromIP := net.ParseIP(from.IP)
+	if remoteIP == nil || fromIP == nil || !remoteIP.Equal(fromIP) {
+		return PeerNode{IP: host}
+	}
+
+	return from
+}
@@ -0,0 +1,198 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_Gossip(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64},
+		Balances:    map[common.Address]uint{sender: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	peer, peerDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(peerDataDir)
+	defer peer.state.Close()
+
+	handler := http.NewServeMux()
+	handler.HandleFunc(endpointGossipBlock, func(w http.ResponseWriter, r *http.Request) {
+		gossipBlockHandler(w, r, peer)
+	})
+	handler.HandleFunc(endpointGossipTx, func(w http.ResponseWriter, r *http.Request) {
+		gossipTxHandler(w, r, peer)
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.ParseUint(serverUrl.Port(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddPeer(NewPeerNode(serverUrl.Hostname(), port, false, database.NewAccount(DefaultMiner), true))
+
+	tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, ""), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddPendingTX(tx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.announce(newPendingTxEvent(txHash, tx))
+
+	if !peer.mempool.Has(txHash) {
+		t.Fatalf("announced TX must be pending on the peer")
+	}
+
+	if _, isSeen := peer.gossipSeen.Origin(txHash); !isSeen {
+		t.Fatalf("announced TX must be remembered by the peer")
+	}
+
+	pb, err := NewPendingBlockFromState(n.state, sender, []database.SignedTx{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := Mine(context.Background(), pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.addBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockHash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.announce(newHeadEvent(blockHash, block))
+
+	// The mining loop isn't running
+	go func() {
+		<-peer.newSyncedBlocks
+	}()
+	peer.importGossipedBlock(<-peer.gossipedBlocks)
+
+	if peer.state.LatestBlockHash() != blockHash || peer.mempool.Has(txHash) {
+		t.Fatalf("announced block must become the peer's head and its TX must leave the peer's Mempool")
+	}
+}
+
+func TestNode_AnnounceOnlyLatestHead(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64},
+		Balances:    map[common.Address]uint{sender: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	announced := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == endpointGossipBlock {
+			announced++
+		}
+		writeRes(w, GossipRes{})
+	}))
+	defer server.Close()
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.ParseUint(serverUrl.Port(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddPeer(NewPeerNode(serverUrl.Hostname(), port, false, database.NewAccount(DefaultMiner), true))
+
+	mineHead := func() Event {
+		tx := database.NewTx(sender, database.NewAccount(testKsAccount1), 1, n.state.GetNextAccountNonce(sender), "")
+		signedTx, err := wallet.SignTx(tx, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pb, err := NewPendingBlockFromState(n.state, sender, []database.SignedTx{signedTx})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := Mine(context.Background(), pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = n.addBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return newHeadEvent(n.state.LatestBlockHash(), block)
+	}
+
+	n.syncTracker.start(0, 1, 1, nil)
+	n.announce(mineHead())
+	n.syncTracker.stop()
+
+	if announced != 0 {
+		t.Fatalf("heads imported during a sync must not be announced")
+	}
+
+	replacedHead := mineHead()
+	head := mineHead()
+	n.announce(replacedHead)
+
+	if announced != 0 {
+		t.Fatalf("head replaced by a newer one must not be announced")
+	}
+
+	n.announce(head)
+	n.announce(head)
+
+	if announced != 1 {
+		t.Fatalf("latest head must be announced once, got %d announcements", announced)
+	}
+}
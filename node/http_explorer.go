@@ -0,0 +1,170 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+)
+
+type BlockRes struct {
+	Hash          database.Hash        `json:"hash"`
+	Header        database.BlockHeader `json:"header"`
+	TxCount       int                  `json:"tx_count"`
+	Confirmations uint64               `json:"confirmations"`
+}
+
+type BlocksRes struct {
+	Blocks []BlockRes `json:"blocks"`
+}
+
+type BlockTx struct {
+	Hash database.Hash     `json:"hash"`
+	Tx   database.SignedTx `json:"tx"`
+}
+
+type BlockTXsRes struct {
+	Hash   database.Hash `json:"block_hash"`
+	Number uint64        `json:"block_number"`
+	TXs    []BlockTx     `json:"txs"`
+}
+
+type MinersStatsRes struct {
+	Miners []database.MinerStats `json:"miners"`
+}
+
+func blockHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	hash, block, err := queryBlock(r, state)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, newBlockRes(state, hash, block))
+}
+
+func blockTXsHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	hash, block, err := queryBlock(r, state)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	txs := make([]BlockTx, len(block.TXs))
+	for i, tx := range block.TXs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		txs[i] = BlockTx{txHash, tx}
+	}
+
+	writeRes(w, BlockTXsRes{hash, block.Header.Number, txs})
+}
+
+func latestBlocksHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	count, err := parseUintQuery(r, endpointBlocksLatestQueryKeyCount, blocksLatestDefaultCount)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if count > blocksLatestMaxCount {
+		count = blocksLatestMaxCount
+	}
+
+	blocks, err := state.GetLatestBlocks(count)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	res := BlocksRes{make([]BlockRes, len(blocks))}
+	for i, block := range blocks {
+		hash, err := block.Hash()
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		res.Blocks[i] = newBlockRes(state, hash, block)
+	}
+
+	writeRes(w, res)
+}
+
+func minersStatsHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	stats, err := state.MinerStats()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, MinersStatsRes{stats})
+}
+
+func chainSummaryHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	summary, err := state.ChainSummary()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, summary)
+}
+
+// Loads the block referenced either by the hash or the canonical height query param
+func queryBlock(r *http.Request, state *database.State) (database.Hash, database.Block, error) {
+	rawHash := r.URL.Query().Get(endpointBlockQueryKeyHash)
+	if rawHash != "" {
+		hash := database.Hash{}
+		err := hash.UnmarshalText([]byte(rawHash))
+		if err != nil {
+			return database.Hash{}, database.Block{}, err
+		}
+
+		block, err := state.GetBlockByHash(hash)
+		if err != nil {
+			return database.Hash{}, database.Block{}, err
+		}
+
+		return hash, block, nil
+	}
+
+	if r.URL.Query().Get(endpointBlockQueryKeyHeight) == "" {
+		return database.Hash{}, database.Block{}, fmt.Errorf("either the '%s' or the '%s' query param is required", endpointBlockQueryKeyHash, endpointBlockQueryKeyHeight)
+	}
+
+	height, err := parseUintQuery(r, endpointBlockQueryKeyHeight, 0)
+	if err != nil {
+		return database.Hash{}, database.Block{}, err
+	}
+
+	block, err := state.GetBlockByHeight(height)
+	if err != nil {
+		return database.Hash{}, database.Block{}, err
+	}
+
+	hash, err := block.Hash()
+	if err != nil {
+		return database.Hash{}, database.Block{}, err
+	}
+
+	return hash, block, nil
+}
+
+func newBlockRes(state *database.State, hash database.Hash, block database.Block) BlockRes {
+	return BlockRes{hash, block.Header, len(block.TXs), state.Confirmations(hash)}
+}
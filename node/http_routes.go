@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/ethanblumenthal/golang-blockchain/database"
-	"github.com/ethanblumenthal/golang-blockchain/wallet"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -19,11 +19,30 @@ type BalancesRes struct {
 	Balances map[common.Address]uint `json:"balances"`
 }
 
+type BalanceProofRes struct {
+	Hash      database.Hash              `json:"block_hash"`
+	Number    uint64                     `json:"block_number"`
+	StateRoot database.Hash              `json:"state_root"`
+	Account   common.Address             `json:"account"`
+	Balance   uint                       `json:"balance"`
+	Nonce     uint                       `json:"nonce"`
+	Proof     []database.MerkleProofNode `json:"proof"`
+}
+
+type AccountTXsRes struct {
+	Account common.Address       `json:"account"`
+	Total   uint64               `json:"total"`
+	Offset  uint64               `json:"offset"`
+	Limit   uint64               `json:"limit"`
+	TXs     []database.AccountTx `json:"txs"`
+}
+
 type TxAddReq struct {
 	From    string `json:"from"`
 	FromPwd string `json:"from_pwd"`
 	To      string `json:"to"`
 	Value   uint   `json:"value"`
+	Fee     uint   `json:"fee"`
 	Data    string `json:"data"`
 }
 
@@ -31,17 +50,67 @@ type TxAddRes struct {
 	Success bool `json:"success"`
 }
 
+type TxSendRawRes struct {
+	Hash database.Hash `json:"hash"`
+}
+
+type TxProofRes struct {
+	BlockHash   database.Hash              `json:"block_hash"`
+	BlockNumber uint64                     `json:"block_number"`
+	TxRoot      database.Hash              `json:"tx_root"`
+	TxHash      database.Hash              `json:"tx_hash"`
+	Proof       []database.MerkleProofNode `json:"proof"`
+}
+
+const TxStatusPending = "pending"
+const TxStatusQueued = "queued"
+const TxStatusMined = "mined"
+const TxStatusUnknown = "unknown"
+
+type TxGetRes struct {
+	Hash          database.Hash      `json:"hash"`
+	Status        string             `json:"status"`
+	Tx            *database.SignedTx `json:"tx"`
+	BlockHash     database.Hash      `json:"block_hash"`
+	BlockNumber   uint64             `json:"block_number"`
+	Index         uint64             `json:"index"`
+	Confirmations uint64             `json:"confirmations"`
+}
+
 type StatusRes struct {
-	Hash       database.Hash       `json:"block_hash"`
-	Number     uint64              `json:"block_number"`
-	KnownPeers map[string]PeerNode `json:"peers_known"`
-	PendingTXs []database.SignedTx `json:"pending_txs"`
+	ChainID         string              `json:"chain_id"`
+	Hash            database.Hash       `json:"block_hash"`
+	Number          uint64              `json:"block_number"`
+	TotalDifficulty uint64              `json:"total_difficulty"`
+	KnownPeers      map[string]PeerNode `json:"peers_known"`
+	PendingTXs      []database.SignedTx `json:"pending_txs"`
+}
+
+// Queued TXs wait for their senders' missing nonces before becoming pending
+type MempoolRes struct {
+	Size    int         `json:"size"`
+	MaxSize int         `json:"max_size"`
+	TXs     []MempoolTx `json:"txs"`
+	Queued  []MempoolTx `json:"queued"`
 }
 
 type SyncRes struct {
 	Blocks []database.Block `json:"blocks"`
 }
 
+type SyncHeadersRes struct {
+	Headers []database.BlockHeaderFS `json:"headers"`
+}
+
+type SyncStatusRes struct {
+	Syncing        bool     `json:"syncing"`
+	StartingHeight uint64   `json:"starting_height"`
+	CurrentHeight  uint64   `json:"current_height"`
+	TargetHeight   uint64   `json:"target_height"`
+	PendingBlocks  int      `json:"pending_blocks"`
+	Peers          []string `json:"peers"`
+}
+
 type AddPeerRes struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error"`
@@ -52,6 +121,71 @@ func listBalancesHandler(w http.ResponseWriter, r *http.Request, state *database
 	writeRes(w, BalancesRes{state.LatestBlockHash(), state.Balances})
 }
 
+func balanceProofHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	account := database.NewAccount(r.URL.Query().Get(endpointBalancesProofQueryKeyAccount))
+
+	proof, err := state.AccountProof(account)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	latestBlock := state.LatestBlock()
+
+	writeRes(w, BalanceProofRes{
+		Hash:      state.LatestBlockHash(),
+		Number:    latestBlock.Header.Number,
+		StateRoot: latestBlock.Header.StateRoot,
+		Account:   account,
+		Balance:   state.Balances[account],
+		Nonce:     state.Account2Nonce[account],
+		Proof:     proof,
+	})
+}
+
+func accountTXsHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	path := strings.TrimPrefix(r.URL.Path, endpointAccounts)
+	if !strings.HasSuffix(path, endpointAccountTXsSuffix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	address := strings.TrimSuffix(path, endpointAccountTXsSuffix)
+	if !common.IsHexAddress(address) {
+		writeErrRes(w, fmt.Errorf("'%s' is an invalid account address", address))
+		return
+	}
+
+	offset, err := parseUintQuery(r, endpointAccountTXsQueryKeyOffset, 0)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	limit, err := parseUintQuery(r, endpointAccountTXsQueryKeyLimit, accountTXsDefaultLimit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if limit > accountTXsMaxLimit {
+		limit = accountTXsMaxLimit
+	}
+
+	account := database.NewAccount(address)
+	txs, total, err := state.GetAccountTXs(account, offset, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AccountTXsRes{account, total, offset, limit, txs})
+}
+
 func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	req := TxAddReq{}
 	err := readReq(r, &req)
@@ -60,48 +194,131 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	from := database.NewAccount(req.From)
-	if from.String() == common.HexToAddress("").String() {
-		writeErrRes(w, fmt.Errorf("%s is an invalid 'from' sender", from.String()))
+	_, err = node.signAndAddPendingTX(req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxAddRes{Success: true})
+}
+
+// Accepts a TX already signed by its sender, the sender's key never leaves the client
+func txSendRawHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	tx := database.SignedTx{}
+	err := readReq(r, &tx)
+	if err != nil {
+		writeErrRes(w, err)
 		return
 	}
 
-	if req.FromPwd == "" {
-		writeErrRes(w, fmt.Errorf("password to decrypt the %s account is required. 'from_pwd' is empty", from.String()))
+	txHash, err := node.addRawPendingTX(tx)
+	if err != nil {
+		writeErrRes(w, err)
 		return
 	}
 
-	nonce := node.state.GetNextAccountNonce(from)
-	tx := database.NewTx(from, database.NewAccount(req.To), req.Value, nonce, req.Data)
+	writeRes(w, TxSendRawRes{txHash})
+}
 
-	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
+func txProofHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	blockHash := database.Hash{}
+	err := blockHash.UnmarshalText([]byte(r.URL.Query().Get(endpointTxProofQueryKeyBlock)))
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
-	err = node.AddPendingTX(signedTx, node.info)
+	txHash := database.Hash{}
+	err = txHash.UnmarshalText([]byte(r.URL.Query().Get(endpointTxProofQueryKeyTx)))
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
-	writeRes(w, TxAddRes{Success: true})
+	block, err := node.state.GetBlockByHash(blockHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	proof, err := database.TxMerkleProof(block.TXs, txHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxProofRes{blockHash, block.Header.Number, block.Header.TxRoot, txHash, proof})
+}
+
+func txGetHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	txHash := database.Hash{}
+	err := txHash.UnmarshalText([]byte(r.URL.Query().Get(endpointTxGetQueryKeyHash)))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	tx, location, isMined, err := node.state.GetTx(txHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if isMined {
+		writeRes(w, TxGetRes{
+			Hash:          txHash,
+			Status:        TxStatusMined,
+			Tx:            &tx,
+			BlockHash:     location.BlockHash,
+			BlockNumber:   location.BlockNumber,
+			Index:         location.Index,
+			Confirmations: node.state.Confirmations(location.BlockHash),
+		})
+		return
+	}
+
+	if pendingTx, isPending := node.mempool.Get(txHash); isPending {
+		writeRes(w, TxGetRes{Hash: txHash, Status: TxStatusPending, Tx: &pendingTx})
+		return
+	}
+
+	if queuedTx, isQueued := node.txQueue.Get(txHash); isQueued {
+		writeRes(w, TxGetRes{Hash: txHash, Status: TxStatusQueued, Tx: &queuedTx})
+		return
+	}
+
+	writeRes(w, TxGetRes{Hash: txHash, Status: TxStatusUnknown})
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	enableCors(&w)
 
 	res := StatusRes{
-		Hash:       node.state.LatestBlockHash(),
-		Number:     node.state.LatestBlock().Header.Number,
-		KnownPeers: node.knownPeers,
-		PendingTXs: node.getPendingTXsAsArray(),
+		ChainID:         node.state.ChainParams().ChainID,
+		Hash:            node.state.LatestBlockHash(),
+		Number:          node.state.LatestBlock().Header.Number,
+		TotalDifficulty: node.state.TotalDifficulty(),
+		KnownPeers:      node.KnownPeers(),
+		PendingTXs:      node.mempool.SortedTXs(),
 	}
 
 	writeRes(w, res)
 }
 
+func mempoolHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	txs := node.mempool.Sorted()
+	writeRes(w, MempoolRes{len(txs), node.mempool.MaxSize(), txs, node.txQueue.TXs()})
+}
+
 func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	reqHash := r.URL.Query().Get(endpointSyncQueryKeyFromBlock)
 
@@ -112,7 +329,17 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	blocks, err := database.GetBlocksAfter(hash, node.dataDir)
+	limit, err := parseUintQuery(r, endpointSyncQueryKeyLimit, syncBlocksBatchSize)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if limit > syncBlocksBatchSize {
+		limit = syncBlocksBatchSize
+	}
+
+	blocks, err := node.state.GetBlocksAfter(hash, limit)
 	if err != nil {
 		writeErrRes(w, err)
 		return
@@ -121,6 +348,76 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, SyncRes{Blocks: blocks})
 }
 
+// Serves the headers following the fromBlock hash or, given a block locator,
+// following the latest locator block on our canonical chain
+func syncHeadersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	limit, err := parseUintQuery(r, endpointSyncQueryKeyLimit, syncHeadersBatchSize)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if limit > syncHeadersBatchSize {
+		limit = syncHeadersBatchSize
+	}
+
+	reqLocator := r.URL.Query().Get(endpointSyncQueryKeyLocator)
+	if reqLocator != "" {
+		locator, err := parseBlockLocator(reqLocator)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		headers, err := node.state.GetHeadersAfterLocator(locator, limit)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		writeRes(w, SyncHeadersRes{Headers: headers})
+		return
+	}
+
+	hash := database.Hash{}
+	err = hash.UnmarshalText([]byte(r.URL.Query().Get(endpointSyncQueryKeyFromBlock)))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	headers, err := node.state.GetHeadersAfter(hash, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, SyncHeadersRes{Headers: headers})
+}
+
+// Parses the comma separated locator hashes, a locator grows with the log of the chain height
+func parseBlockLocator(reqLocator string) ([]database.Hash, error) {
+	hexHashes := strings.Split(reqLocator, ",")
+	if len(hexHashes) > syncMaxLocatorHashes {
+		return nil, fmt.Errorf("block locator must have at most %d hashes not %d", syncMaxLocatorHashes, len(hexHashes))
+	}
+
+	locator := make([]database.Hash, len(hexHashes))
+	for i, hexHash := range hexHashes {
+		err := locator[i].UnmarshalText([]byte(hexHash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return locator, nil
+}
+
+func syncStatusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+	writeRes(w, node.syncTracker.status(node.state.LatestBlock().Header.Number))
+}
+
 func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	peerIP := r.URL.Query().Get(endpointAddPeerQueryKeyIP)
 	peerPortRaw := r.URL.Query().Get(endpointAddPeerQueryKeyPort)
@@ -132,7 +429,13 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
+	// A banned peer can't come back by announcing another address
 	peer := NewPeerNode(peerIP, peerPort, false, database.NewAccount(minerRaw), true)
+	if node.IsBannedPeer(r.RemoteAddr) || node.IsBannedPeer(peer.TcpAddress()) {
+		writeRes(w, AddPeerRes{false, fmt.Sprintf("peer '%s' is banned", peer.TcpAddress())})
+		return
+	}
+
 	node.AddPeer(peer)
 	fmt.Printf("Peer '%s' was added into known peers\n", peer.TcpAddress())
 
@@ -0,0 +1,66 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_TxSendRaw(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{sender: 1000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, ""), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendRaw := func(tx database.SignedTx) *httptest.ResponseRecorder {
+		txJson, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		txSendRawHandler(w, httptest.NewRequest(http.MethodPost, endpointTxSendRaw, bytes.NewBuffer(txJson)), n)
+
+		return w
+	}
+
+	forgedTx := tx
+	forgedTx.Value = 900
+
+	if w := sendRaw(forgedTx); w.Code == http.StatusOK || n.mempool.Len() != 0 {
+		t.Fatalf("TX with a forged signature must be rejected")
+	}
+
+	w := sendRaw(tx)
+
+	var res TxSendRawRes
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK || res.Hash != txHash || !n.mempool.Has(txHash) {
+		t.Fatalf("signed TX must be added into the Mempool, got %s", w.Body.String())
+	}
+}
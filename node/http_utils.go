@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 func writeErrRes(w http.ResponseWriter, err error) {
@@ -62,4 +63,19 @@ func readRes(r *http.Response, reqBody interface{}) error {
 
 func enableCors(w *http.ResponseWriter) {
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-}
\ No newline at end of file
+}
+
+// Parses an unsigned integer query param, falling back to the default when it's missing
+func parseUintQuery(r *http.Request, key string, defaultValue uint64) (uint64, error) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("query param '%s' must be a non-negative integer. %s", key, err.Error())
+	}
+
+	return value, nil
+}
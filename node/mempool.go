@@ -0,0 +1,277 @@
+package node
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const DefaultMempoolMaxSize = 5000
+const DefaultMempoolMaxPerSender = 64
+const DefaultMempoolTTL = 3 * time.Hour
+
+// A replacement TX must raise the pending TX's fee by this percentage and by at least the min TX fee,
+// so that re-broadcasting the same TX for a token more can't flood the network
+const replacementFeeBumpPercent = 10
+
+// A pending TX waiting to be mined
+type MempoolTx struct {
+	Hash    database.Hash     `json:"hash"`
+	Tx      database.SignedTx `json:"tx"`
+	Fee     uint              `json:"fee"`
+	AddedAt time.Time         `json:"added_at"`
+}
+
+// Pending TXs bounded in total and per sender. When full, the lowest fee TX
+// is evicted in favour of a better paying one, TXs expire after the TTL
+type Mempool struct {
+	mu           sync.RWMutex
+	maxSize      int
+	maxPerSender int
+	ttl          time.Duration
+	txs          map[database.Hash]MempoolTx
+	senders      map[common.Address]int
+}
+
+func NewMempool(maxSize int, maxPerSender int, ttl time.Duration) *Mempool {
+	return &Mempool{
+		maxSize:      maxSize,
+		maxPerSender: maxPerSender,
+		ttl:          ttl,
+		txs:          make(map[database.Hash]MempoolTx),
+		senders:      make(map[common.Address]int),
+	}
+}
+
+// Adds the TX and returns the hashes of the TXs evicted to make room for it
+func (m *Mempool) Add(tx MempoolTx) ([]database.Hash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, isKnown := m.txs[tx.Hash]; isKnown {
+		return nil, nil
+	}
+
+	if m.senders[tx.Tx.From] >= m.maxPerSender {
+		return nil, fmt.Errorf("sender '%s' already has %d pending TXs", tx.Tx.From.String(), m.maxPerSender)
+	}
+
+	evicted := make([]database.Hash, 0)
+	if len(m.txs) >= m.maxSize {
+		cheapest, ok := m.cheapestEvictable()
+		if !ok || cheapest.Fee >= tx.Fee {
+			return nil, fmt.Errorf("mempool is full, TX fee must be higher than %d", cheapest.Fee)
+		}
+
+		m.remove(cheapest.Hash)
+		evicted = append(evicted, cheapest.Hash)
+	}
+
+	m.txs[tx.Hash] = tx
+	m.senders[tx.Tx.From]++
+
+	return evicted, nil
+}
+
+// Swaps a pending TX for one with the same sender and nonce, the sender's limit doesn't apply
+func (m *Mempool) Replace(replaced database.Hash, tx MempoolTx) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, isKnown := m.txs[replaced]
+	if !isKnown {
+		return fmt.Errorf("TX '%s' to replace isn't pending", replaced.Hex())
+	}
+
+	if old.Tx.From != tx.Tx.From || old.Tx.Nonce != tx.Tx.Nonce {
+		return fmt.Errorf("replacement TX must have the same sender and nonce as TX '%s'", replaced.Hex())
+	}
+
+	delete(m.txs, replaced)
+	m.txs[tx.Hash] = tx
+
+	return nil
+}
+
+func (m *Mempool) Remove(hash database.Hash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(hash)
+}
+
+func (m *Mempool) remove(hash database.Hash) {
+	tx, isKnown := m.txs[hash]
+	if !isKnown {
+		return
+	}
+
+	delete(m.txs, hash)
+
+	m.senders[tx.Tx.From]--
+	if m.senders[tx.Tx.From] == 0 {
+		delete(m.senders, tx.Tx.From)
+	}
+}
+
+// Removes the TXs pending for longer than the TTL and returns their hashes
+func (m *Mempool) Expire(now time.Time) []database.Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := make([]database.Hash, 0)
+	for hash, tx := range m.txs {
+		if now.Sub(tx.AddedAt) > m.ttl {
+			expired = append(expired, hash)
+		}
+	}
+
+	for _, hash := range expired {
+		m.remove(hash)
+	}
+
+	return expired
+}
+
+func (m *Mempool) Get(hash database.Hash) (database.SignedTx, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tx, isKnown := m.txs[hash]
+	return tx.Tx, isKnown
+}
+
+func (m *Mempool) GetByNonce(account common.Address, nonce uint) (MempoolTx, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, tx := range m.txs {
+		if tx.Tx.From == account && tx.Tx.Nonce == nonce {
+			return tx, true
+		}
+	}
+
+	return MempoolTx{}, false
+}
+
+func (m *Mempool) Has(hash database.Hash) bool {
+	_, isKnown := m.Get(hash)
+	return isKnown
+}
+
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.txs)
+}
+
+func (m *Mempool) MaxSize() int {
+	return m.maxSize
+}
+
+// Orders the TXs by fee, highest first, while keeping every sender's TXs in nonce order
+func (m *Mempool) Sorted() []MempoolTx {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	queues := m.senderQueues()
+
+	heads := make(mempoolHeads, 0, len(queues))
+	for _, queue := range queues {
+		heads = append(heads, queue)
+	}
+	heap.Init(&heads)
+
+	sorted := make([]MempoolTx, 0, len(m.txs))
+	for heads.Len() > 0 {
+		queue := heads[0]
+		sorted = append(sorted, queue[0])
+
+		if len(queue) == 1 {
+			heap.Pop(&heads)
+			continue
+		}
+
+		heads[0] = queue[1:]
+		heap.Fix(&heads, 0)
+	}
+
+	return sorted
+}
+
+func (m *Mempool) SortedTXs() []database.SignedTx {
+	sorted := m.Sorted()
+
+	txs := make([]database.SignedTx, len(sorted))
+	for i, tx := range sorted {
+		txs[i] = tx.Tx
+	}
+
+	return txs
+}
+
+// Groups the TXs by sender, each group ordered by nonce
+func (m *Mempool) senderQueues() map[common.Address][]MempoolTx {
+	queues := make(map[common.Address][]MempoolTx)
+	for _, tx := range m.txs {
+		queues[tx.Tx.From] = append(queues[tx.Tx.From], tx)
+	}
+
+	for _, queue := range queues {
+		queue := queue
+		sort.Slice(queue, func(i, j int) bool {
+			return queue[i].Tx.Nonce < queue[j].Tx.Nonce
+		})
+	}
+
+	return queues
+}
+
+// Only the highest nonce TX of a sender is evictable,
+// evicting a lower one would leave a gap the later TXs can't be mined past
+func (m *Mempool) cheapestEvictable() (MempoolTx, bool) {
+	var cheapest MempoolTx
+	found := false
+
+	for _, queue := range m.senderQueues() {
+		last := queue[len(queue)-1]
+
+		if !found || last.Fee < cheapest.Fee || (last.Fee == cheapest.Fee && last.AddedAt.After(cheapest.AddedAt)) {
+			cheapest = last
+			found = true
+		}
+	}
+
+	return cheapest, found
+}
+
+// Max-heap of the senders' nonce ordered queues by the fee of their first TX
+type mempoolHeads [][]MempoolTx
+
+func (h mempoolHeads) Len() int { return len(h) }
+
+func (h mempoolHeads) Less(i, j int) bool {
+	if h[i][0].Fee != h[j][0].Fee {
+		return h[i][0].Fee > h[j][0].Fee
+	}
+
+	return h[i][0].AddedAt.Before(h[j][0].AddedAt)
+}
+
+func (h mempoolHeads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mempoolHeads) Push(x interface{}) { *h = append(*h, x.([]MempoolTx)) }
+
+func (h *mempoolHeads) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return last
+}
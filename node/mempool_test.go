@@ -0,0 +1,241 @@
+package node
+
+import (
+	"crypto/ecdsa"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestMempoolTx(t *testing.T, from common.Address, nonce uint, fee uint, addedAt time.Time) MempoolTx {
+	tx := database.NewSignedTx(database.NewTx(from, database.NewAccount(testKsAccount2), 1, nonce, ""), nil)
+
+	hash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return MempoolTx{hash, tx, fee, addedAt}
+}
+
+func TestMempool_Sorted(t *testing.T) {
+	now := time.Now()
+	alice := database.NewAccount(testKsAccount1)
+	bob := database.NewAccount(testKsAccount2)
+
+	m := NewMempool(10, 10, time.Hour)
+	aliceTx2 := newTestMempoolTx(t, alice, 2, 50, now)
+	aliceTx1 := newTestMempoolTx(t, alice, 1, 1, now)
+	bobTx1 := newTestMempoolTx(t, bob, 1, 10, now)
+
+	for _, tx := range []MempoolTx{aliceTx2, aliceTx1, bobTx1} {
+		if _, err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Alice's nonce 1 TX must go first despite its low fee as her nonce 2 TX depends on it
+	expected := []database.Hash{bobTx1.Hash, aliceTx1.Hash, aliceTx2.Hash}
+	for i, tx := range m.Sorted() {
+		if tx.Hash != expected[i] {
+			t.Fatalf("TX %d must be %s, got %s", i, expected[i].Hex(), tx.Hash.Hex())
+		}
+	}
+}
+
+func TestMempool_Limits(t *testing.T) {
+	now := time.Now()
+	alice := database.NewAccount(testKsAccount1)
+	bob := database.NewAccount(testKsAccount2)
+
+	m := NewMempool(2, 1, time.Hour)
+	aliceTx1 := newTestMempoolTx(t, alice, 1, 5, now)
+	if _, err := m.Add(aliceTx1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Add(newTestMempoolTx(t, alice, 2, 5, now)); err == nil {
+		t.Fatalf("TX over the sender's limit must be rejected")
+	}
+
+	if _, err := m.Add(newTestMempoolTx(t, bob, 1, 10, now)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Add(newTestMempoolTx(t, common.HexToAddress("0x01"), 1, 5, now)); err == nil {
+		t.Fatalf("TX not paying more than the cheapest pending TX must be rejected when the Mempool is full")
+	}
+
+	evicted, err := m.Add(newTestMempoolTx(t, common.HexToAddress("0x01"), 1, 20, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(evicted) != 1 || evicted[0] != aliceTx1.Hash || m.Has(aliceTx1.Hash) || m.Len() != 2 {
+		t.Fatalf("the cheapest TX must be evicted in favour of a higher fee one, evicted %v", evicted)
+	}
+}
+
+func TestMempool_Expire(t *testing.T) {
+	now := time.Now()
+	alice := database.NewAccount(testKsAccount1)
+
+	m := NewMempool(10, 10, time.Hour)
+	oldTx := newTestMempoolTx(t, alice, 1, 1, now.Add(-2*time.Hour))
+	newTx := newTestMempoolTx(t, alice, 2, 1, now)
+
+	for _, tx := range []MempoolTx{oldTx, newTx} {
+		if _, err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired := m.Expire(now)
+	if len(expired) != 1 || expired[0] != oldTx.Hash || !m.Has(newTx.Hash) {
+		t.Fatalf("only the TX pending for longer than the TTL must expire, expired %v", expired)
+	}
+}
+
+func TestNode_ReplacePendingTX(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{sender: 1000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	signTx := func(tx database.Tx) (database.SignedTx, database.Hash) {
+		signedTx, err := wallet.SignTx(tx, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txHash, err := signedTx.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signedTx, txHash
+	}
+
+	tx, txHash := signTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, ""))
+	err = n.AddPendingTX(tx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samePriceTx, _ := signTx(database.NewTx(sender, database.NewAccount(testKsAccount2), 100, 1, ""))
+	if err = n.AddPendingTX(samePriceTx, n.info); err == nil {
+		t.Fatalf("replacement TX not paying a higher fee must be rejected")
+	}
+
+	cheapBumpTx, _ := signTx(database.NewTx(sender, sender, 0, 1, "").WithFee(tx.Fee + 1))
+	if err = n.AddPendingTX(cheapBumpTx, n.info); err == nil {
+		t.Fatalf("replacement TX raising the fee by less than the min bump must be rejected")
+	}
+
+	cancelTx, cancelTxHash := signTx(database.NewTx(sender, sender, 0, 1, "").WithFee(minReplacementFee(tx.Fee, n.state.ChainParams().MinTxFee)))
+	err = n.AddPendingTX(cancelTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.mempool.Has(txHash) || !n.mempool.Has(cancelTxHash) || n.mempool.Len() != 1 {
+		t.Fatalf("higher fee TX must replace the pending TX with the same nonce")
+	}
+
+	if n.pendingState.Balances[sender] != 1000-cancelTx.Cost() {
+		t.Fatalf("pending state must be re-computed with the replacement TX, sender balance is %d", n.pendingState.Balances[sender])
+	}
+}
+
+func TestMinReplacementFee(t *testing.T) {
+	tests := []struct {
+		fee      uint
+		minTxFee uint
+		expected uint
+	}{
+		{50, 50, 100},
+		{1000, 50, 1100},
+		{^uint(0) - 10, 50, ^uint(0)},
+	}
+
+	for _, test := range tests {
+		if fee := minReplacementFee(test.fee, test.minTxFee); fee != test.expected {
+			t.Fatalf("replacing a TX paying %d must cost at least %d, got %d", test.fee, test.expected, fee)
+		}
+	}
+}
+
+func TestNode_ConcurrentAddPendingTX(t *testing.T) {
+	const senders = 8
+	const txsPerSender = 5
+
+	balances := make(map[common.Address]uint)
+	keys := make([]*ecdsa.PrivateKey, senders)
+	for i := range keys {
+		privKey, _, sender, err := generateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys[i] = privKey
+		balances[sender] = 1000
+	}
+
+	n, dataDir := newTestNodeWithState(t, balances)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	// Each sender's TXs are added in reverse nonce order so they get queued and promoted
+	var wg sync.WaitGroup
+	for _, privKey := range keys {
+		wg.Add(1)
+		go func(privKey *ecdsa.PrivateKey) {
+			defer wg.Done()
+
+			sender := crypto.PubkeyToAddress(privKey.PublicKey)
+			for nonce := uint(txsPerSender); nonce > 0; nonce-- {
+				tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 1, nonce, ""), privKey)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				err = n.AddPendingTX(tx, n.info)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(privKey)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < txsPerSender; i++ {
+			n.expirePendingTXs()
+		}
+	}()
+
+	wg.Wait()
+
+	if n.mempool.Len() != senders*txsPerSender {
+		t.Fatalf("all TXs must be pending, got %d", n.mempool.Len())
+	}
+
+	for sender := range balances {
+		if n.pendingState.GetNextAccountNonce(sender) != txsPerSender+1 {
+			t.Fatalf("pending state must apply all the TXs of sender %s", sender.String())
+		}
+	}
+}
@@ -2,7 +2,9 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
@@ -12,15 +14,86 @@ import (
 )
 
 type PendingBlock struct {
-	parent database.Hash
-	number uint64
-	time   uint64
-	miner  common.Address
-	txs    []database.SignedTx
+	parent     database.Hash
+	number     uint64
+	time       uint64
+	miner      common.Address
+	difficulty uint64
+	stateRoot  database.Hash
+	txs        []database.SignedTx
 }
 
 func NewPendingBlock(parent database.Hash, number uint64, miner common.Address, txs []database.SignedTx) PendingBlock {
-	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, txs}
+	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, database.DefaultDifficulty, database.Hash{}, txs}
+}
+
+// Prepares the next block on top of the state's latest block, committing to the state it will produce.
+// The TXs are expected best paying first, those not fitting into the block limits are left out
+func NewPendingBlockFromState(state *database.State, miner common.Address, txs []database.SignedTx) (PendingBlock, error) {
+	pb := PendingBlock{state.LatestBlockHash(), state.NextBlockNumber(), state.NextBlockTime(), miner, state.NextDifficulty(), database.Hash{}, nil}
+
+	txs, err := selectBlockTXs(state, pb, txs)
+	if err != nil {
+		return PendingBlock{}, err
+	}
+
+	stateRoot, err := state.NextStateRoot(miner, txs)
+	if err != nil {
+		return PendingBlock{}, err
+	}
+
+	pb.stateRoot = stateRoot
+	pb.txs = txs
+
+	return pb, nil
+}
+
+// Fills the block template in the given order up to the max block TXs count and size.
+// Once a sender's TX is left out, the sender's following TXs are too as their nonces would leave a gap
+func selectBlockTXs(state *database.State, pb PendingBlock, txs []database.SignedTx) ([]database.SignedTx, error) {
+	params := state.ChainParams()
+
+	// The largest nonce makes the size an upper bound of the mined block's size
+	emptyBlock, err := database.NewBlock(pb.parent, pb.number, math.MaxUint32, pb.time, pb.miner, pb.difficulty, pb.stateRoot, []database.SignedTx{})
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := emptyBlock.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	templateState := state.Copy()
+	leftOut := make(map[common.Address]bool)
+	selected := make([]database.SignedTx, 0)
+
+	for _, tx := range txs {
+		if uint64(len(selected)) == params.MaxBlockTXs {
+			break
+		}
+
+		if leftOut[tx.From] {
+			continue
+		}
+
+		txJson, err := json.Marshal(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Including the separator from the previous TX
+		txSize := uint64(len(txJson)) + 1
+		if size+txSize > params.MaxBlockSize || database.ApplyTx(tx, &templateState) != nil {
+			leftOut[tx.From] = true
+			continue
+		}
+
+		size += txSize
+		selected = append(selected, tx)
+	}
+
+	return selected, nil
 }
 
 func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
@@ -30,11 +103,15 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 
 	start := time.Now()
 	attempt := 0
-	var block database.Block
 	var hash database.Hash
-	var nonce uint32
 
-	for !database.IsBlockHashValid(hash) {
+	block, err := database.NewBlock(pb.parent, pb.number, 0, pb.time, pb.miner, pb.difficulty, pb.stateRoot, pb.txs)
+	if err != nil {
+		return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
+	}
+
+	// The empty hash is below any target, at least one attempt must be made
+	for attempt == 0 || !database.IsBlockHashValid(hash, pb.difficulty) {
 		select {
 		case <-ctx.Done():
 			fmt.Println("Mining cancelled!")
@@ -43,13 +120,12 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 		}
 
 		attempt++
-		nonce = generateNonce()
+		block.Header.Nonce = generateNonce()
 
 		if attempt%1000000 == 0 || attempt == 1 {
 			fmt.Printf("Mining %d pending TXs. Attempt: %d\n", len(pb.txs), attempt)
 		}
 
-		block = database.NewBlock(pb.parent, pb.number, nonce, pb.time, pb.miner, pb.txs)
 		blockHash, err := block.Hash()
 		if err != nil {
 			return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
@@ -63,6 +139,7 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 	fmt.Printf("\tNonce: '%v'\n", block.Header.Nonce)
 	fmt.Printf("\tCreated: '%v'\n", block.Header.Time)
 	fmt.Printf("\tMiner: '%v'\n", block.Header.Miner.String())
+	fmt.Printf("\tDifficulty: '%v'\n", block.Header.Difficulty)
 	fmt.Printf("\tParent: '%v'\n\n", block.Header.Parent.Hex())
 	fmt.Printf("\tAttempt: '%v'\n", attempt)
 	fmt.Printf("\tTime: %s\n\n", time.Since(start))
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
 	"github.com/ethanblumenthal/golang-blockchain/wallet"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -21,7 +22,7 @@ func TestValidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if !isValid {
 		t.Fatalf("hash '%s' starting with 6 zeroes is suppose to be valid", hexHash)
 	}
@@ -33,7 +34,7 @@ func TestInvalidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if isValid {
 		t.Fatal("hash is not suppose to be valid")
 	}
@@ -62,7 +63,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !database.IsBlockHashValid(minedBlockHash) {
+	if !database.IsBlockHashValid(minedBlockHash, database.DefaultDifficulty) {
 		t.Fatal()
 	}
 
@@ -90,6 +91,62 @@ func TestMineWithTimeout(t *testing.T) {
 	}
 }
 
+func TestNewPendingBlockFromState_Limits(t *testing.T) {
+	privKey1, _, account1, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey2, _, account2, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{MaxBlockTXs: 2},
+		Balances:    map[common.Address]uint{account1: 1000, account2: 1000},
+	}
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	mempool := NewMempool(10, 10, time.Hour)
+	txs := []struct {
+		privKey *ecdsa.PrivateKey
+		tx      database.Tx
+	}{
+		{privKey1, database.NewTx(account1, account2, 1, 1, "")},
+		{privKey1, database.NewTx(account1, account2, 1, 2, "")},
+		{privKey2, database.NewTx(account2, account1, 1, 1, "").WithFee(90)},
+	}
+
+	for _, tx := range txs {
+		signedTx, err := wallet.SignTx(tx.tx, tx.privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txHash, err := signedTx.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = mempool.Add(MempoolTx{txHash, signedTx, signedTx.Fee, time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pb, err := NewPendingBlockFromState(n.state, account1, mempool.SortedTXs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pb.txs) != 2 || pb.txs[0].From != account2 || pb.txs[1].Nonce != 1 {
+		t.Fatalf("block template must contain the best paying TX and account1's first TX, got %v", pb.txs)
+	}
+}
+
 func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
 	privKey, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
 	if err != nil {
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -20,7 +23,46 @@ const HttpSSLPort = 443
 const endpointStatus = "/node/status"
 
 const endpointSync = "/node/sync"
+const endpointSyncHeaders = "/node/sync/headers"
 const endpointSyncQueryKeyFromBlock = "fromBlock"
+const endpointSyncQueryKeyLimit = "limit"
+const endpointSyncQueryKeyLocator = "locator"
+const endpointSyncStatus = "/node/sync/status"
+
+const endpointBalancesProof = "/balances/proof"
+const endpointBalancesProofQueryKeyAccount = "account"
+
+const endpointTxProof = "/tx/proof"
+const endpointTxProofQueryKeyBlock = "block"
+const endpointTxProofQueryKeyTx = "tx"
+
+const endpointTxSendRaw = "/tx/sendRaw"
+
+const endpointTxGet = "/tx/get"
+const endpointTxGetQueryKeyHash = "hash"
+
+// Serves "/accounts/{address}/txs"
+const endpointAccounts = "/accounts/"
+const endpointAccountTXsSuffix = "/txs"
+const endpointAccountTXsQueryKeyOffset = "offset"
+const endpointAccountTXsQueryKeyLimit = "limit"
+const accountTXsDefaultLimit = 20
+const accountTXsMaxLimit = 100
+
+const endpointBlock = "/blocks/get"
+const endpointBlockTXs = "/blocks/txs"
+const endpointBlockQueryKeyHash = "hash"
+const endpointBlockQueryKeyHeight = "height"
+
+const endpointBlocksLatest = "/blocks/latest"
+const endpointBlocksLatestQueryKeyCount = "count"
+const blocksLatestDefaultCount = 10
+const blocksLatestMaxCount = 100
+
+const endpointMinersStats = "/miners/stats"
+const endpointChainSummary = "/chain/summary"
+
+const endpointMempool = "/mempool"
 
 const endpointAddPeer = "/node/peer"
 const endpointAddPeerQueryKeyIP = "ip"
@@ -41,11 +83,21 @@ type Node struct {
 	info            PeerNode
 	state           *database.State
 	pendingState    *database.State
+	pendingMu       sync.Mutex // Guards pendingState and archivedTXs
 	knownPeers      map[string]PeerNode
-	pendingTXs      map[string]database.SignedTx
+	peerStats       map[string]PeerStats
+	knownPeersMu    sync.RWMutex
+	bootstraps      []PeerNode
+	bans            map[string]PeerBan
+	bansMu          sync.Mutex
+	mempool         *Mempool
+	txQueue         *TxQueue
 	archivedTXs     map[string]database.SignedTx
 	newSyncedBlocks chan database.Block
-	newPendingTXs   chan database.SignedTx
+	gossipedBlocks  chan database.Block
+	gossipSeen      *seenHashes
+	syncTracker     *syncTracker
+	events          *eventBus
 	isMining        bool
 }
 
@@ -61,21 +113,42 @@ func (pn PeerNode) ApiProtocol() string {
 	return "http"
 }
 
-func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode) *Node {
+// Restores the peers known and banned before the node's restart and adds the bootstrap peers
+func New(dataDir string, ip string, port uint64, acc common.Address, bootstraps ...PeerNode) *Node {
 	knownPeers := make(map[string]PeerNode)
 
 	n := &Node{
 		dataDir:         dataDir,
 		info:            NewPeerNode(ip, port, false, acc, true),
 		knownPeers:      knownPeers,
-		pendingTXs:      make(map[string]database.SignedTx),
+		peerStats:       make(map[string]PeerStats),
+		bootstraps:      bootstraps,
+		bans:            make(map[string]PeerBan),
+		mempool:         NewMempool(DefaultMempoolMaxSize, DefaultMempoolMaxPerSender, DefaultMempoolTTL),
+		txQueue:         NewTxQueue(DefaultTxQueueMaxSize, DefaultTxQueueMaxPerSender, DefaultTxQueueMaxNonceGap, DefaultMempoolTTL),
 		archivedTXs:     make(map[string]database.SignedTx),
 		newSyncedBlocks: make(chan database.Block),
-		newPendingTXs:   make(chan database.SignedTx, 10000),
+		gossipedBlocks:  make(chan database.Block, gossipedBlocksBuffer),
+		gossipSeen:      newSeenHashes(gossipSeenHashesSize),
+		syncTracker:     &syncTracker{},
+		events:          newEventBus(),
 		isMining:        false,
 	}
 
-	n.AddPeer(bootstrap)
+	peers, err := loadPeers(dataDir)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+	n.restorePeers(peers)
+
+	bans, err := loadBans(dataDir)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+	n.restoreBans(bans)
+
+	n.addBootstraps()
+
 	return n
 }
 
@@ -103,6 +176,7 @@ func (n *Node) Run(ctx context.Context, isSSLDisabled bool, sslEmail string) err
 
 	go n.sync(ctx)
 	go n.mine(ctx)
+	go n.gossip(ctx)
 
 	return n.serveHttp(ctx, isSSLDisabled, sslEmail)
 }
@@ -118,11 +192,63 @@ func (n *Node) serveHttp(ctx context.Context, isSSLDisabled bool, sslEmail strin
 		listBalancesHandler(w, r, n.state)
 	})
 
+	handler.HandleFunc(endpointBalancesProof, func(w http.ResponseWriter, r *http.Request) {
+		balanceProofHandler(w, r, n.state)
+	})
+
 	handler.HandleFunc("/tx/add", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Hi")
 		txAddHandler(w, r, n)
 	})
 
+	handler.HandleFunc(endpointTxSendRaw, func(w http.ResponseWriter, r *http.Request) {
+		txSendRawHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointTxProof, func(w http.ResponseWriter, r *http.Request) {
+		txProofHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointTxGet, func(w http.ResponseWriter, r *http.Request) {
+		txGetHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointAccounts, func(w http.ResponseWriter, r *http.Request) {
+		accountTXsHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointBlock, func(w http.ResponseWriter, r *http.Request) {
+		blockHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointBlockTXs, func(w http.ResponseWriter, r *http.Request) {
+		blockTXsHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointBlocksLatest, func(w http.ResponseWriter, r *http.Request) {
+		latestBlocksHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointMinersStats, func(w http.ResponseWriter, r *http.Request) {
+		minersStatsHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointChainSummary, func(w http.ResponseWriter, r *http.Request) {
+		chainSummaryHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointMempool, func(w http.ResponseWriter, r *http.Request) {
+		mempoolHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointRPC, func(w http.ResponseWriter, r *http.Request) {
+		rpcHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointWS, func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(w, r, n)
+	})
+
 	handler.HandleFunc(endpointStatus, func(w http.ResponseWriter, r *http.Request) {
 		statusHandler(w, r, n)
 	})
@@ -131,10 +257,38 @@ func (n *Node) serveHttp(ctx context.Context, isSSLDisabled bool, sslEmail strin
 		syncHandler(w, r, n)
 	})
 
+	handler.HandleFunc(endpointSyncHeaders, func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointSyncStatus, func(w http.ResponseWriter, r *http.Request) {
+		syncStatusHandler(w, r, n)
+	})
+
 	handler.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, r *http.Request) {
 		addPeerHandler(w, r, n)
 	})
 
+	handler.HandleFunc(endpointPeers, func(w http.ResponseWriter, r *http.Request) {
+		peersHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointPeersBan, func(w http.ResponseWriter, r *http.Request) {
+		banPeerHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointPeersUnban, func(w http.ResponseWriter, r *http.Request) {
+		unbanPeerHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointGossipBlock, func(w http.ResponseWriter, r *http.Request) {
+		gossipBlockHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointGossipTx, func(w http.ResponseWriter, r *http.Request) {
+		gossipTxHandler(w, r, n)
+	})
+
 	if isSSLDisabled {
 		server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port), Handler: handler}
 
@@ -164,8 +318,10 @@ func (n *Node) mine(ctx context.Context) error {
 	for {
 		select {
 		case <-ticker.C:
+			n.expirePendingTXs()
+
 			go func() {
-				if len(n.pendingTXs) > 0 && !n.isMining {
+				if n.mempool.Len() > 0 && !n.isMining {
 					n.isMining = true
 
 					miningCtx, stopCurrentMining = context.WithCancel(ctx)
@@ -183,7 +339,6 @@ func (n *Node) mine(ctx context.Context) error {
 				blockHash, _ := block.Hash()
 				fmt.Printf("\nPeer mined next Block '%s' faster :(\n", blockHash.Hex())
 
-				n.removeMinedPendingTXs(block)
 				stopCurrentMining()
 			}
 
@@ -195,50 +350,76 @@ func (n *Node) mine(ctx context.Context) error {
 }
 
 func (n *Node) minePendingTXs(ctx context.Context) error {
-	blockToMine := NewPendingBlock(
-		n.state.LatestBlockHash(),
-		n.state.NextBlockNumber(),
-		n.info.Account,
-		n.getPendingTXsAsArray(),
-	)
-
-	minedBlock, err := Mine(ctx, blockToMine)
+	blockToMine, err := NewPendingBlockFromState(n.state, n.info.Account, n.mempool.SortedTXs())
 	if err != nil {
 		return err
 	}
 
-	n.removeMinedPendingTXs(minedBlock)
-
-	err = n.addBlock(minedBlock)
+	minedBlock, err := Mine(ctx, blockToMine)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// The mined block could end up on a side branch if the chain moved meanwhile,
+	// its TXs are then left in the Mempool
+	return n.addBlock(minedBlock)
 }
 
 func (n *Node) removeMinedPendingTXs(block database.Block) {
-	if len(block.TXs) > 0 && len(n.pendingTXs) > 0 {
+	if len(block.TXs) > 0 && n.mempool.Len() > 0 {
 		fmt.Println("Updating in-memory pending TXs pool:")
 	}
 
 	for _, tx := range block.TXs {
 		txHash, _ := tx.Hash()
-		if _, exists := n.pendingTXs[txHash.Hex()]; exists {
+		if n.mempool.Has(txHash) {
 			fmt.Printf("\t-archiving mined TX: %s\n", txHash.Hex())
 
 			n.archivedTXs[txHash.Hex()] = tx
-			delete(n.pendingTXs, txHash.Hex())
+			n.mempool.Remove(txHash)
 		}
 	}
 }
 
+// Drops TXs pending or queued for longer than the Mempool TTL
+func (n *Node) expirePendingTXs() {
+	for _, txHash := range n.txQueue.Expire(time.Now()) {
+		fmt.Printf("Queued TX %s expired\n", txHash.Hex())
+	}
+
+	expired := n.mempool.Expire(time.Now())
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, txHash := range expired {
+		fmt.Printf("Pending TX %s expired\n", txHash.Hex())
+	}
+
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+
+	n.resetPendingState()
+}
+
 func (n *Node) AddPeer(peer PeerNode) {
+	n.knownPeersMu.Lock()
+	defer n.knownPeersMu.Unlock()
+
 	n.knownPeers[peer.TcpAddress()] = peer
+
+	// A new peer gets as long as a peer last seen now to become reachable
+	if _, hasStats := n.peerStats[peer.TcpAddress()]; !hasStats {
+		n.peerStats[peer.TcpAddress()] = PeerStats{LastSeen: uint64(time.Now().Unix())}
+	}
 }
 
 func (n *Node) RemovePeer(peer PeerNode) {
+	n.knownPeersMu.Lock()
+	defer n.knownPeersMu.Unlock()
+
 	delete(n.knownPeers, peer.TcpAddress())
+	delete(n.peerStats, peer.TcpAddress())
 }
 
 func (n *Node) IsKnownPeer(peer PeerNode) bool {
@@ -246,11 +427,40 @@ func (n *Node) IsKnownPeer(peer PeerNode) bool {
 		return true
 	}
 
-	_, isKnownPeer := n.knownPeers[peer.TcpAddress()]
+	_, isKnownPeer := n.KnownPeer(peer.TcpAddress())
 	return isKnownPeer
 }
 
+func (n *Node) KnownPeer(tcpAddress string) (PeerNode, bool) {
+	n.knownPeersMu.RLock()
+	defer n.knownPeersMu.RUnlock()
+
+	peer, isKnownPeer := n.knownPeers[tcpAddress]
+	return peer, isKnownPeer
+}
+
+// Copy of the known peers, safe to range over while peers are added or removed
+func (n *Node) KnownPeers() map[string]PeerNode {
+	n.knownPeersMu.RLock()
+	defer n.knownPeersMu.RUnlock()
+
+	knownPeers := make(map[string]PeerNode, len(n.knownPeers))
+	for tcpAddress, peer := range n.knownPeers {
+		knownPeers[tcpAddress] = peer
+	}
+
+	return knownPeers
+}
+
 func (n *Node) AddPendingTX(tx database.SignedTx, fromPeer PeerNode) error {
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+
+	return n.addPendingTX(tx, fromPeer)
+}
+
+// Adds the TX with pendingMu held, queued and orphaned TXs are added while it already is
+func (n *Node) addPendingTX(tx database.SignedTx, fromPeer PeerNode) error {
 	txHash, err := tx.Hash()
 	if err != nil {
 		return err
@@ -261,48 +471,250 @@ func (n *Node) AddPendingTX(tx database.SignedTx, fromPeer PeerNode) error {
 		return err
 	}
 
+	_, isArchived := n.archivedTXs[txHash.Hex()]
+	if n.mempool.Has(txHash) || n.txQueue.Has(txHash) || isArchived {
+		return nil
+	}
+
+	nextNonce := n.pendingState.GetNextAccountNonce(tx.From)
+	if tx.Nonce > nextNonce {
+		return n.queueTX(MempoolTx{txHash, tx, tx.Fee, time.Now()}, nextNonce, fromPeer)
+	}
+
+	if replaced, isPending := n.mempool.GetByNonce(tx.From, tx.Nonce); isPending {
+		return n.replacePendingTX(replaced, MempoolTx{txHash, tx, tx.Fee, time.Now()}, fromPeer)
+	}
+
 	err = n.validateTxBeforeAddingToMempool(tx)
 	if err != nil {
 		return err
 	}
 
-	_, isAlreadyPending := n.pendingTXs[txHash.Hex()]
-	_, isArchived := n.archivedTXs[txHash.Hex()]
+	evicted, err := n.mempool.Add(MempoolTx{txHash, tx, tx.Fee, time.Now()})
+	if err != nil {
+		// The TX was already applied to the pending state
+		n.resetPendingState()
+		return err
+	}
 
-	if !isAlreadyPending && !isArchived {
-		fmt.Printf("Added pending TX %s from peer %s\n", txJson, fromPeer.TcpAddress())
-		n.pendingTXs[txHash.Hex()] = tx
-		n.newPendingTXs <- tx
+	fmt.Printf("Added pending TX %s from peer %s\n", txJson, fromPeer.TcpAddress())
+	n.events.Publish(newPendingTxEvent(txHash, tx))
+
+	if len(evicted) > 0 {
+		for _, evictedHash := range evicted {
+			fmt.Printf("Evicted lowest fee pending TX %s\n", evictedHash.Hex())
+		}
+
+		n.resetPendingState()
 	}
 
+	n.promoteQueuedTX(tx.From)
+
 	return nil
 }
 
+// Replaces the pending TX with a better paying one of the same sender and nonce,
+// e.g. to bump a stuck TX or to cancel it
+func (n *Node) replacePendingTX(replaced MempoolTx, tx MempoolTx, fromPeer PeerNode) error {
+	minFee := minReplacementFee(replaced.Fee, n.state.ChainParams().MinTxFee)
+	if tx.Fee < minFee {
+		return fmt.Errorf("TX '%s' with nonce '%d' is already pending, a replacement TX fee must be at least %d", replaced.Hash.Hex(), tx.Tx.Nonce, minFee)
+	}
+
+	// The replacement must apply where the replaced TX did, its followers are re-validated by resetPendingState
+	pendingState := n.state.Copy()
+	for _, pending := range n.mempool.Sorted() {
+		if pending.Hash != replaced.Hash {
+			_ = database.ApplyTx(pending.Tx, &pendingState)
+			continue
+		}
+
+		err := database.ApplyTx(tx.Tx, &pendingState)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := n.mempool.Replace(replaced.Hash, tx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replaced pending TX %s with TX %s from peer %s\n", replaced.Hash.Hex(), tx.Hash.Hex(), fromPeer.TcpAddress())
+	n.events.Publish(newPendingTxEvent(tx.Hash, tx.Tx))
+
+	n.resetPendingState()
+
+	return nil
+}
+
+// Fee a TX replacing a pending TX paying the given fee must at least pay
+func minReplacementFee(fee uint, minTxFee uint) uint {
+	bump := fee * replacementFeeBumpPercent / 100
+	if bump < minTxFee {
+		bump = minTxFee
+	}
+
+	if fee > ^uint(0)-bump {
+		return ^uint(0)
+	}
+
+	return fee + bump
+}
+
+// Holds a TX arriving ahead of its sender's missing TXs until they are pending
+func (n *Node) queueTX(tx MempoolTx, nextNonce uint, fromPeer PeerNode) error {
+	err := database.ValidateTxIntrinsic(tx.Tx, n.state.ChainParams())
+	if err != nil {
+		return err
+	}
+
+	err = n.txQueue.Add(tx, nextNonce)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Queued TX %s from peer %s until the sender's nonce '%d' is pending\n", tx.Hash.Hex(), fromPeer.TcpAddress(), nextNonce)
+
+	return nil
+}
+
+// Moves the sender's queued TX with the next nonce into the Mempool,
+// adding it promotes the following one
+func (n *Node) promoteQueuedTX(account common.Address) {
+	tx, isQueued := n.txQueue.Pop(account, n.pendingState.GetNextAccountNonce(account))
+	if !isQueued {
+		return
+	}
+
+	err := n.addPendingTX(tx.Tx, n.info)
+	if err != nil {
+		fmt.Printf("Queued TX %s dropped. %s\n", tx.Hash.Hex(), err)
+	}
+}
+
+// Drops the queued TXs whose nonces got taken and promotes those whose gap got filled
+func (n *Node) promoteQueuedTXs() {
+	for _, account := range n.txQueue.Accounts() {
+		for _, txHash := range n.txQueue.Prune(account, n.pendingState.GetNextAccountNonce(account)) {
+			fmt.Printf("Queued TX %s dropped. Its nonce is already taken\n", txHash.Hex())
+		}
+
+		n.promoteQueuedTX(account)
+	}
+}
+
+// Signs the requested TX with the sender's account from the node's keystore and adds it into the Mempool
+func (n *Node) signAndAddPendingTX(req TxAddReq) (database.Hash, error) {
+	from := database.NewAccount(req.From)
+	if from.String() == common.HexToAddress("").String() {
+		return database.Hash{}, fmt.Errorf("%s is an invalid 'from' sender", from.String())
+	}
+
+	if req.FromPwd == "" {
+		return database.Hash{}, fmt.Errorf("password to decrypt the %s account is required. 'from_pwd' is empty", from.String())
+	}
+
+	fee := req.Fee
+	if fee == 0 {
+		fee = n.state.ChainParams().MinTxFee
+	}
+
+	nonce := n.state.GetNextAccountNonce(from)
+	tx := database.NewTx(from, database.NewAccount(req.To), req.Value, nonce, req.Data).WithChainID(n.state.ChainParams().ChainID).WithFee(fee)
+
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, from, req.FromPwd, wallet.GetKeystoreDirPath(n.dataDir))
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return n.addRawPendingTX(signedTx)
+}
+
+// Adds a TX signed by its sender into the Mempool
+func (n *Node) addRawPendingTX(tx database.SignedTx) (database.Hash, error) {
+	txHash, err := tx.Hash()
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	err = n.AddPendingTX(tx, n.info)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return txHash, nil
+}
+
+// Imports a mined or synced block and publishes it when it becomes the new head
 func (n *Node) addBlock(block database.Block) error {
-	_, err := n.state.AddBlock(block)
+	blockHash, orphanedTXs, err := n.state.ImportBlock(block)
 	if err != nil {
 		return err
 	}
 
-	// Reset the pending state
-	pendingState := n.state.Copy()
-	n.pendingState = &pendingState
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+
+	if n.state.LatestBlockHash() == blockHash {
+		n.removeMinedPendingTXs(block)
+		n.events.Publish(newHeadEvent(blockHash, block))
+	}
+
+	n.resetPendingState()
+	n.reinjectOrphanedTXs(orphanedTXs)
 
 	return nil
 }
 
-func (n *Node) validateTxBeforeAddingToMempool(tx database.SignedTx) error {
-	return database.ApplyTx(tx, n.pendingState)
+// Rebuilds the pending state from the latest block and the TXs left in the Mempool.
+// TXs which no longer apply, e.g. mined by a peer, are dropped. TXs following
+// a dropped one are queued until the gap fills again. Called with pendingMu held
+func (n *Node) resetPendingState() {
+	pendingState := n.state.Copy()
+
+	for _, tx := range n.mempool.Sorted() {
+		err := database.ApplyTx(tx.Tx, &pendingState)
+		if err == nil {
+			continue
+		}
+
+		n.mempool.Remove(tx.Hash)
+
+		nextNonce := pendingState.GetNextAccountNonce(tx.Tx.From)
+		if tx.Tx.Nonce > nextNonce && n.txQueue.Add(tx, nextNonce) == nil {
+			fmt.Printf("Pending TX %s queued until the sender's nonce '%d' is pending\n", tx.Hash.Hex(), nextNonce)
+			continue
+		}
+
+		fmt.Printf("Pending TX %s dropped. %s\n", tx.Hash.Hex(), err)
+	}
+
+	n.pendingState = &pendingState
+	n.promoteQueuedTXs()
 }
 
-func (n *Node) getPendingTXsAsArray() []database.SignedTx {
-	txs := make([]database.SignedTx, len(n.pendingTXs))
+// Returns TXs of blocks abandoned by a chain reorganization back into the Mempool
+func (n *Node) reinjectOrphanedTXs(txs []database.SignedTx) {
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
+	for _, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			continue
+		}
+
+		delete(n.archivedTXs, txHash.Hex())
 
-	i := 0
-	for _, tx := range n.pendingTXs {
-		txs[i] = tx
-		i++
+		err = n.addPendingTX(tx, n.info)
+		if err != nil {
+			fmt.Printf("Orphaned TX %s dropped. %s\n", txHash.Hex(), err)
+		}
 	}
+}
 
-	return txs
-}
\ No newline at end of file
+func (n *Node) validateTxBeforeAddingToMempool(tx database.SignedTx) error {
+	return database.ApplyTx(tx, n.pendingState)
+}
@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -317,6 +318,46 @@ func TestNode_ReplayedTx(t *testing.T) {
 	}
 }
 
+func TestNode_WrongChainTx(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{ChainID: "gochain-test"},
+		Balances:    map[common.Address]uint{sender: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	tx := database.NewTx(sender, database.NewAccount(testKsAccount1), 100, 1, "").WithChainID("gochain-other")
+	signedTx, err := wallet.SignTx(tx, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = n.AddPendingTX(signedTx, n.info); err == nil {
+		t.Fatalf("TX signed for another chain must be rejected")
+	}
+
+	if n.mempool.Len() != 0 {
+		t.Fatalf("TX signed for another chain must not enter the mempool")
+	}
+
+	signedTx, err = wallet.SignTx(tx.WithChainID("gochain-test"), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddPendingTX(signedTx, n.info)
+	if err != nil {
+		t.Fatalf("TX signed for the node's chain must be accepted. %s", err)
+	}
+}
+
 func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	account2 := database.NewAccount(testKsAccount1)
 	account1 := database.NewAccount(testKsAccount2)
@@ -380,7 +421,17 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// Pre-mine a valid block without running the `n.Run()`
 	// with account1 as a miner who will receive the block reward,
 	// to simulate the block came on the fly from another peer
-	validPreMinedPb := NewPendingBlock(database.Hash{}, 0, account1, []database.SignedTx{signedTx1})
+	genesisState, err := database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validPreMinedPb, err := NewPendingBlockFromState(genesisState, account1, []database.SignedTx{signedTx1})
+	genesisState.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	validSyncedBlock, err := Mine(ctx, validPreMinedPb)
 	if err != nil {
 		t.Fatal(err)
@@ -407,7 +458,8 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 			t.Fatal("should be mining")
 		}
 
-		_, err := n.state.AddBlock(validSyncedBlock)
+		// Imported blocks which become the head leave the Mempool
+		err := n.addBlock(validSyncedBlock)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -420,9 +472,9 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		}
 
 		// Mined TX1 by account1 should be removed from the Mempool
-		_, onlyTX2IsPending := n.pendingTXs[tx2Hash.Hex()]
+		onlyTX2IsPending := n.mempool.Has(tx2Hash)
 
-		if len(n.pendingTXs) != 1 && !onlyTX2IsPending {
+		if n.mempool.Len() != 1 && !onlyTX2IsPending {
 			t.Fatal("synced block should have canceled mining of already mined TX")
 		}
 
@@ -465,8 +517,9 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 
 		// In TX1 account1 transferred 1 token to account2
 		// In TX2 account1 transferred 2 tokens to account2
-		expectedEndAccount1Balance := startingAccount1Balance - tx1.Cost() - tx2.Cost() + database.BlockReward + database.TxFee
-		expectedEndAccount2Balance := startingAccount2Balance + tx1.Value + tx2.Value + database.BlockReward + database.TxFee
+		params := n.state.ChainParams()
+		expectedEndAccount1Balance := startingAccount1Balance - tx1.Cost() - tx2.Cost() + params.BlockReward + params.MinTxFee
+		expectedEndAccount2Balance := startingAccount2Balance + tx1.Value + tx2.Value + params.BlockReward + params.MinTxFee
 
 		if endAccount1Balance != expectedEndAccount1Balance {
 			t.Errorf("account1 expected end balance is %d not %d", expectedEndAccount1Balance, endAccount1Balance)
@@ -488,11 +541,98 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		t.Fatal("was suppose to mine 2 pending TX into 2 valid blocks under 30m")
 	}
 
-	if len(n.pendingTXs) != 0 {
+	if n.mempool.Len() != 0 {
 		t.Fatal("no pending TXs should be left to mine")
 	}
 }
 
+func TestNode_ReinjectOrphanedTXs(t *testing.T) {
+	privKey1, _, sender1, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey2, _, sender2, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64},
+		Balances:    map[common.Address]uint{sender1: 1000, sender2: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	peer, peerDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(peerDataDir)
+	defer peer.state.Close()
+
+	recipient := database.NewAccount(testKsAccount1)
+	mineBlock := func(state *database.State, miner common.Address, tx database.Tx, privKey *ecdsa.PrivateKey) database.Block {
+		signedTx, err := wallet.SignTx(tx, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pb, err := NewPendingBlockFromState(state, miner, []database.SignedTx{signedTx})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := Mine(context.Background(), pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return block
+	}
+
+	genesisBlock := mineBlock(peer.state, sender2, database.NewTx(sender1, recipient, 10, 1, ""), privKey1)
+	for _, node := range []*Node{n, peer} {
+		if err = node.addBlock(genesisBlock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The node's branch holds sender1's TX, the peer's heavier branch doesn't
+	block := mineBlock(n.state, n.info.Account, database.NewTx(sender1, recipient, 20, 2, ""), privKey1)
+	if err = n.addBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	orphanedTx := block.TXs[0]
+	orphanedTxHash, err := orphanedTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for nonce := uint(1); nonce <= 2; nonce++ {
+		peerBlock := mineBlock(peer.state, peer.info.Account, database.NewTx(sender2, recipient, 10, nonce, ""), privKey2)
+		if err = peer.addBlock(peerBlock); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = n.addBlock(peerBlock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n.state.LatestBlockHash() != peer.state.LatestBlockHash() {
+		t.Fatalf("node must reorganize onto the peer's heavier branch")
+	}
+
+	if !n.mempool.Has(orphanedTxHash) {
+		t.Fatalf("TX of the abandoned block must be pending again")
+	}
+
+	if n.pendingState.Account2Nonce[sender1] != 2 {
+		t.Fatalf("orphaned TX must apply to the pending state, sender nonce is %d", n.pendingState.Account2Nonce[sender1])
+	}
+}
+
 func TestNode_MiningSpamTransactions(t *testing.T) {
 	account1Balance := uint(1000)
 	account2Balance := uint(0)
@@ -554,9 +694,10 @@ func TestNode_MiningSpamTransactions(t *testing.T) {
 	// Run the node, mining and everything in a blocking call (hence the go-routines before)
 	_ = n.Run(ctx, true, "")
 
-	expectedAccount1Balance := account1Balance - (txCount * txValue) - (txCount * database.TxFee)
+	params := n.state.ChainParams()
+	expectedAccount1Balance := account1Balance - (txCount * txValue) - (txCount * params.MinTxFee)
 	expectedAccount2Balance := account2Balance + (txCount * txValue)
-	expectedMinerBalance := minerBalance + database.BlockReward + (txCount * database.TxFee)
+	expectedMinerBalance := minerBalance + params.BlockReward + (txCount * params.MinTxFee)
 
 	if n.state.Balances[account1] != expectedAccount1Balance {
 		t.Errorf("account1 balance is incorrect. Expected: %d. Got: %d", expectedAccount1Balance, n.state.Balances[account1])
@@ -653,4 +794,38 @@ func setupTestNodeDir(account1Balance uint) (dataDir string, account1, account2
 	}
 
 	return dataDir, account1, account2, nil
-}
\ No newline at end of file
+}
+
+// Creates a node with its state loaded from a fresh data dir, without running it
+func newTestNodeWithState(t *testing.T, genesisBalances map[common.Address]uint) (*Node, string) {
+	return newTestNodeWithGenesis(t, database.Genesis{Balances: genesisBalances})
+}
+
+func newTestNodeWithGenesis(t *testing.T, genesis database.Genesis) (*Node, string) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesisJson, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.InitDataDirIfNotExists(dataDir, genesisJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(dataDir, "127.0.0.1", 8085, database.NewAccount(DefaultMiner), PeerNode{})
+
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingState := n.state.Copy()
+	n.pendingState = &pendingState
+
+	return n, dataDir
+}
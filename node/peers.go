@@ -0,0 +1,128 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const peersFileName = "peers.json"
+
+// Unreachable peers not seen for longer are forgotten, bootstrap peers are always kept
+const peerForgetAfter = 24 * time.Hour
+
+// How the node's connections to a known peer went, persisted with the peer.
+// The score grows with every successful sync and drops when the peer misbehaves
+type PeerStats struct {
+	LastSeen  uint64 `json:"last_seen"`
+	Successes uint64 `json:"successes"`
+	Failures  uint64 `json:"failures"`
+	Score     int    `json:"score"`
+}
+
+type PeerRecord struct {
+	Peer  PeerNode  `json:"peer"`
+	Stats PeerStats `json:"stats"`
+}
+
+func getPeersFilePath(dataDir string) string {
+	return filepath.Join(dataDir, peersFileName)
+}
+
+// Returns the peers persisted in the data dir, none when the node never saved its peers
+func loadPeers(dataDir string) ([]PeerRecord, error) {
+	peersJson, err := ioutil.ReadFile(getPeersFilePath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []PeerRecord
+	err = json.Unmarshal(peersJson, &records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peers file '%s'. %s", getPeersFilePath(dataDir), err)
+	}
+
+	return records, nil
+}
+
+// Persists the known peers and their stats so a restarted node reconnects to the network
+// even if its bootstrap peers are down
+func (n *Node) savePeers() error {
+	return writeJsonFile(getPeersFilePath(n.dataDir), n.PeerRecords())
+}
+
+// Replaces the file at once so a crash never leaves it half written
+func writeJsonFile(path string, content interface{}) error {
+	contentJson, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, contentJson, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (n *Node) restorePeers(records []PeerRecord) {
+	n.knownPeersMu.Lock()
+	defer n.knownPeersMu.Unlock()
+
+	for _, record := range records {
+		n.knownPeers[record.Peer.TcpAddress()] = record.Peer
+		n.peerStats[record.Peer.TcpAddress()] = record.Stats
+	}
+}
+
+// Known peers with their stats, ordered by address
+func (n *Node) PeerRecords() []PeerRecord {
+	n.knownPeersMu.RLock()
+	defer n.knownPeersMu.RUnlock()
+
+	records := make([]PeerRecord, 0, len(n.knownPeers))
+	for tcpAddress, peer := range n.knownPeers {
+		records = append(records, PeerRecord{peer, n.peerStats[tcpAddress]})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Peer.TcpAddress() < records[j].Peer.TcpAddress()
+	})
+
+	return records
+}
+
+func (n *Node) recordPeerSuccess(peer PeerNode) {
+	n.knownPeersMu.Lock()
+	defer n.knownPeersMu.Unlock()
+
+	stats := n.peerStats[peer.TcpAddress()]
+	stats.Successes++
+	stats.LastSeen = uint64(time.Now().Unix())
+	if stats.Score < peerMaxScore {
+		stats.Score++
+	}
+	n.peerStats[peer.TcpAddress()] = stats
+}
+
+// Records the failed connection and reports whether the peer should be forgotten
+func (n *Node) recordPeerFailure(peer PeerNode) bool {
+	n.knownPeersMu.Lock()
+	defer n.knownPeersMu.Unlock()
+
+	stats := n.peerStats[peer.TcpAddress()]
+	stats.Failures++
+	n.peerStats[peer.TcpAddress()] = stats
+
+	lastSeen := time.Unix(int64(stats.LastSeen), 0)
+	return !peer.IsBootstrap && time.Since(lastSeen) > peerForgetAfter
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_PersistPeers(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	miner := database.NewAccount(DefaultMiner)
+	bootstrap1 := NewPeerNode("127.0.0.1", 8081, true, miner, false)
+	bootstrap2 := NewPeerNode("127.0.0.1", 8082, true, miner, false)
+	peer := NewPeerNode("127.0.0.1", 8083, false, miner, true)
+
+	n := New(dataDir, "127.0.0.1", 8085, miner, bootstrap1, bootstrap2)
+	n.AddPeer(peer)
+	n.recordPeerSuccess(peer)
+	n.recordPeerFailure(peer)
+
+	err = n.savePeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted without any bootstrap peer
+	n = New(dataDir, "127.0.0.1", 8085, miner)
+
+	records := n.PeerRecords()
+	if len(records) != 3 {
+		t.Fatalf("all known peers must be restored, got %d", len(records))
+	}
+
+	if records[2].Peer.TcpAddress() != peer.TcpAddress() || records[2].Stats.Successes != 1 || records[2].Stats.Failures != 1 {
+		t.Fatalf("peer must be restored with its stats, got %+v", records[2])
+	}
+
+	if restoredPeer, _ := n.KnownPeer(peer.TcpAddress()); restoredPeer.connected {
+		t.Fatalf("restored peer must join the peer's known peers again")
+	}
+}
+
+func TestNode_ForgetUnreachablePeers(t *testing.T) {
+	miner := database.NewAccount(DefaultMiner)
+	bootstrap := NewPeerNode("127.0.0.1", 8081, true, miner, false)
+	peer := NewPeerNode("127.0.0.1", 8083, false, miner, false)
+
+	n := New(t.TempDir(), "127.0.0.1", 8085, miner, bootstrap)
+	n.AddPeer(peer)
+
+	if n.recordPeerFailure(peer) {
+		t.Fatalf("recently added peer must not be forgotten on its first failure")
+	}
+
+	lastSeen := uint64(time.Now().Add(-2 * peerForgetAfter).Unix())
+	n.restorePeers([]PeerRecord{{peer, PeerStats{LastSeen: lastSeen}}, {bootstrap, PeerStats{LastSeen: lastSeen}}})
+
+	if !n.recordPeerFailure(peer) {
+		t.Fatalf("peer unreachable for longer than %s must be forgotten", peerForgetAfter)
+	}
+
+	if n.recordPeerFailure(bootstrap) {
+		t.Fatalf("bootstrap peer must never be forgotten")
+	}
+}
+
+func TestNode_UnreachablePeerIsNotBanned(t *testing.T) {
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	// Nothing listens on the port
+	peer := NewPeerNode("127.0.0.1", 1, false, database.NewAccount(DefaultMiner), false)
+	n.AddPeer(peer)
+
+	for i := 0; i <= -peerBanScore; i++ {
+		n.doSync()
+	}
+
+	if n.IsBannedPeer(peer.TcpAddress()) || !n.IsKnownPeer(peer) {
+		t.Fatalf("offline peer must be left to be forgotten, not banned")
+	}
+}
@@ -0,0 +1,274 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const endpointRPC = "/rpc"
+
+const rpcVersion = "2.0"
+
+// JSON-RPC 2.0 error codes
+const rpcErrParse = -32700
+const rpcErrInvalidRequest = -32600
+const rpcErrMethodNotFound = -32601
+const rpcErrInvalidParams = -32602
+const rpcErrServer = -32000
+
+// Requests without an ID are notifications and get no response
+type RPCReq struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type RPCRes struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// Positional params are decoded into the method's arguments
+type rpcMethod func(n *Node, params []json.RawMessage) (interface{}, error)
+
+var rpcMethods = map[string]rpcMethod{
+	"chain_getBlockByNumber": rpcGetBlockByNumber,
+	"tx_send":                rpcSendTx,
+	"tx_sendRaw":             rpcSendRawTx,
+	"account_getBalance":     rpcGetBalance,
+	"account_getNonce":       rpcGetNonce,
+	"node_peers":             rpcPeers,
+}
+
+func rpcHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRes(w, newRPCErrRes(nil, rpcErrParse, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []json.RawMessage
+		err = json.Unmarshal(body, &reqs)
+		if err != nil {
+			writeRes(w, newRPCErrRes(nil, rpcErrParse, err.Error()))
+			return
+		}
+
+		if len(reqs) == 0 {
+			writeRes(w, newRPCErrRes(nil, rpcErrInvalidRequest, "empty batch"))
+			return
+		}
+
+		responses := make([]RPCRes, 0, len(reqs))
+		for _, rawReq := range reqs {
+			res, isNotification := node.handleRPC(rawReq)
+			if !isNotification {
+				responses = append(responses, res)
+			}
+		}
+
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeRes(w, responses)
+		return
+	}
+
+	res, isNotification := node.handleRPC(body)
+	if isNotification {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeRes(w, res)
+}
+
+// Executes a single JSON-RPC request and reports whether it was a notification
+func (n *Node) handleRPC(rawReq json.RawMessage) (RPCRes, bool) {
+	var req RPCReq
+	err := json.Unmarshal(rawReq, &req)
+	if err != nil {
+		return newRPCErrRes(nil, rpcErrInvalidRequest, err.Error()), false
+	}
+
+	isNotification := len(req.ID) == 0
+
+	if req.JSONRPC != rpcVersion || req.Method == "" {
+		return newRPCErrRes(req.ID, rpcErrInvalidRequest, fmt.Sprintf("request must be JSON-RPC '%s' with a method", rpcVersion)), isNotification
+	}
+
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return newRPCErrRes(req.ID, rpcErrMethodNotFound, fmt.Sprintf("method '%s' not found", req.Method)), isNotification
+	}
+
+	params := make([]json.RawMessage, 0)
+	if len(req.Params) > 0 {
+		err = json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return newRPCErrRes(req.ID, rpcErrInvalidParams, "params must be an array"), isNotification
+		}
+	}
+
+	result, err := method(n, params)
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			return newRPCErrRes(req.ID, rpcErr.Code, rpcErr.Message), isNotification
+		}
+
+		return newRPCErrRes(req.ID, rpcErrServer, err.Error()), isNotification
+	}
+
+	return RPCRes{JSONRPC: rpcVersion, ID: req.ID, Result: result}, isNotification
+}
+
+func newRPCErrRes(id json.RawMessage, code int, message string) RPCRes {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return RPCRes{JSONRPC: rpcVersion, ID: id, Error: &RPCError{code, message}}
+}
+
+// Decodes the positional params into the given values
+func decodeRPCParams(params []json.RawMessage, values ...interface{}) error {
+	if len(params) != len(values) {
+		return &RPCError{rpcErrInvalidParams, fmt.Sprintf("expected %d params, got %d", len(values), len(params))}
+	}
+
+	for i, param := range params {
+		err := json.Unmarshal(param, values[i])
+		if err != nil {
+			return &RPCError{rpcErrInvalidParams, fmt.Sprintf("param %d is invalid. %s", i, err.Error())}
+		}
+	}
+
+	return nil
+}
+
+func decodeRPCAccount(params []json.RawMessage) (common.Address, error) {
+	var address string
+	err := decodeRPCParams(params, &address)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	if !common.IsHexAddress(address) {
+		return common.Address{}, &RPCError{rpcErrInvalidParams, fmt.Sprintf("'%s' is an invalid account address", address)}
+	}
+
+	return database.NewAccount(address), nil
+}
+
+// Params: block height or "latest"
+func rpcGetBlockByNumber(n *Node, params []json.RawMessage) (interface{}, error) {
+	var rawNumber interface{}
+	err := decodeRPCParams(params, &rawNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var block database.Block
+	switch number := rawNumber.(type) {
+	case string:
+		if number != "latest" {
+			return nil, &RPCError{rpcErrInvalidParams, fmt.Sprintf("block number must be a height or 'latest', not '%s'", number)}
+		}
+
+		block, err = n.state.GetBlockByHash(n.state.LatestBlockHash())
+	case float64:
+		if number < 0 || number != float64(uint64(number)) {
+			return nil, &RPCError{rpcErrInvalidParams, fmt.Sprintf("block number %v is invalid", number)}
+		}
+
+		block, err = n.state.GetBlockByHeight(uint64(number))
+	default:
+		return nil, &RPCError{rpcErrInvalidParams, "block number must be a height or 'latest'"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := block.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return database.BlockFS{Key: hash, Value: block}, nil
+}
+
+// Params: TX add request, signed with the node's keystore
+func rpcSendTx(n *Node, params []json.RawMessage) (interface{}, error) {
+	var req TxAddReq
+	err := decodeRPCParams(params, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.signAndAddPendingTX(req)
+}
+
+// Params: TX signed by the sender
+func rpcSendRawTx(n *Node, params []json.RawMessage) (interface{}, error) {
+	var tx database.SignedTx
+	err := decodeRPCParams(params, &tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.addRawPendingTX(tx)
+}
+
+// Params: account address
+func rpcGetBalance(n *Node, params []json.RawMessage) (interface{}, error) {
+	account, err := decodeRPCAccount(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.state.Balances[account], nil
+}
+
+// Params: account address. Returns the nonce of the account's latest mined TX
+func rpcGetNonce(n *Node, params []json.RawMessage) (interface{}, error) {
+	account, err := decodeRPCAccount(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.state.Account2Nonce[account], nil
+}
+
+func rpcPeers(n *Node, params []json.RawMessage) (interface{}, error) {
+	err := decodeRPCParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.KnownPeers(), nil
+}
@@ -0,0 +1,87 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_RPC(t *testing.T) {
+	privKey, _, account1, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	account2 := database.NewAccount(testKsAccount2)
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{account1: 1000000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	signedTx, err := wallet.SignTx(database.NewTx(account1, account2, 100, 1, ""), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedTxJson, err := json.Marshal(signedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := fmt.Sprintf(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "account_getBalance", "params": ["%s"]},
+		{"jsonrpc": "2.0", "id": 2, "method": "tx_sendRaw", "params": [%s]},
+		{"jsonrpc": "2.0", "method": "node_peers"},
+		{"jsonrpc": "2.0", "id": 3, "method": "account_mine"},
+		{"jsonrpc": "2.0", "id": 4, "method": "account_getNonce", "params": []}
+	]`, account1.String(), signedTxJson)
+
+	w := httptest.NewRecorder()
+	rpcHandler(w, httptest.NewRequest(http.MethodPost, endpointRPC, bytes.NewBufferString(batch)), n)
+
+	var responses []struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != 4 {
+		t.Fatalf("a batch of 4 requests and a notification must return 4 responses, got %d", len(responses))
+	}
+
+	if responses[0].Error != nil || string(responses[0].Result) != "1000000" {
+		t.Fatalf("account_getBalance must return 1000000, got %s", responses[0].Result)
+	}
+
+	txHash, err := signedTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if responses[1].Error != nil || string(responses[1].Result) != fmt.Sprintf(`"%s"`, txHash.Hex()) {
+		t.Fatalf("tx_sendRaw must return the TX hash, got %s", w.Body.String())
+	}
+
+	if !n.mempool.Has(txHash) {
+		t.Fatalf("TX sent with tx_sendRaw must be pending")
+	}
+
+	if responses[2].Error == nil || responses[2].Error.Code != rpcErrMethodNotFound {
+		t.Fatalf("unknown method must fail with the %d code", rpcErrMethodNotFound)
+	}
+
+	if responses[3].Error == nil || responses[3].Error.Code != rpcErrInvalidParams {
+		t.Fatalf("missing params must fail with the %d code", rpcErrInvalidParams)
+	}
+}
@@ -4,11 +4,83 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethanblumenthal/golang-blockchain/database"
 )
 
+// Headers per /node/sync/headers page and blocks per /node/sync batch
+const syncHeadersBatchSize = 500
+const syncBlocksBatchSize = 50
+
+// Block locator hashes served at most, enough for any chain height
+const syncMaxLocatorHashes = 64
+
+// Block batches downloaded at once, each from another peer when there are enough peers ahead
+const syncMaxParallelFetches = 4
+
+type peerStatus struct {
+	peer   PeerNode
+	status StatusRes
+}
+
+// Progress of the running block sync reported by /node/sync/status
+type syncTracker struct {
+	mu             sync.Mutex
+	syncing        bool
+	startingHeight uint64
+	targetHeight   uint64
+	pendingBlocks  int
+	peers          []string
+}
+
+func (t *syncTracker) start(startingHeight uint64, targetHeight uint64, pendingBlocks int, peers []PeerNode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.syncing = true
+	t.startingHeight = startingHeight
+	t.targetHeight = targetHeight
+	t.pendingBlocks = pendingBlocks
+	t.peers = make([]string, len(peers))
+	for i, peer := range peers {
+		t.peers[i] = peer.TcpAddress()
+	}
+}
+
+func (t *syncTracker) update(pendingBlocks int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pendingBlocks = pendingBlocks
+}
+
+func (t *syncTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.syncing = false
+	t.pendingBlocks = 0
+	t.peers = nil
+}
+
+func (t *syncTracker) isSyncing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.syncing
+}
+
+func (t *syncTracker) status(currentHeight uint64) SyncStatusRes {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return SyncStatusRes{t.syncing, t.startingHeight, currentHeight, t.targetHeight, t.pendingBlocks, t.peers}
+}
+
 func (n *Node) sync(ctx context.Context) error {
 	n.doSync()
 	ticker := time.NewTicker(45 * time.Second)
@@ -18,14 +90,22 @@ func (n *Node) sync(ctx context.Context) error {
 		case <-ticker.C:
 			n.doSync()
 
+		case block := <-n.gossipedBlocks:
+			n.importGossipedBlock(block)
+
 		case <-ctx.Done():
 			ticker.Stop()
+			return nil
 		}
 	}
 }
 
 func (n *Node) doSync() {
-	for _, peer := range n.knownPeers {
+	peerStatuses := make([]peerStatus, 0)
+
+	n.addBootstraps()
+
+	for _, peer := range n.KnownPeers() {
 		if n.info.IP == peer.IP && n.info.Port == peer.Port {
 			continue
 		}
@@ -38,19 +118,26 @@ func (n *Node) doSync() {
 		status, err := queryPeerStatus(peer)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
-			fmt.Printf("Peer '%s' was removed from known peers\n", peer.TcpAddress())
 
-			n.RemovePeer(peer)
+			// Being offline isn't misbehaving, the peer is forgotten once unreachable for too long, never banned
+			if n.recordPeerFailure(peer) {
+				fmt.Printf("Peer '%s' was unreachable for too long and was removed from known peers\n", peer.TcpAddress())
+				n.RemovePeer(peer)
+			}
 			continue
 		}
-
-		err = n.joinKnownPeers(peer)
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
+		n.recordPeerSuccess(peer)
+
+		// Banned so it isn't added again as soon as another peer lists it
+		if status.ChainID != n.state.ChainParams().ChainID {
+			err = n.BanPeer(peer.TcpAddress(), peerBanDuration, fmt.Sprintf("Peer is on chain '%s', not '%s'", status.ChainID, n.state.ChainParams().ChainID))
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+			}
 			continue
 		}
 
-		err = n.syncBlocks(peer, status)
+		err = n.joinKnownPeers(peer)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
 			continue
@@ -62,51 +149,236 @@ func (n *Node) doSync() {
 			continue
 		}
 
-		err = n.syncPendingTXs(peer, status.PendingTXs)
+		peerStatuses = append(peerStatuses, peerStatus{peer, status})
+	}
+
+	err := n.syncBlocks(peerStatuses)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+
+	for _, ps := range peerStatuses {
+		err = n.syncPendingTXs(ps.peer, ps.status.PendingTXs)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
 			continue
 		}
 	}
+
+	err = n.savePeers()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
 }
 
-func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
+// Syncs the headers from the peer with the longest chain first,
+// then downloads the blocks in batches from all the peers ahead of us in parallel
+func (n *Node) syncBlocks(peerStatuses []peerStatus) error {
+	ahead := make([]peerStatus, 0)
+	for _, ps := range peerStatuses {
+		if n.isPeerAhead(ps.status) {
+			ahead = append(ahead, ps)
+		}
+	}
+
+	if len(ahead) == 0 {
+		return nil
+	}
+
+	sort.Slice(ahead, func(i, j int) bool {
+		return ahead[i].status.TotalDifficulty > ahead[j].status.TotalDifficulty
+	})
+
+	best := ahead[0]
 	localBlockNumber := n.state.LatestBlock().Header.Number
 
+	// A heavier chain may be shorter than ours
+	if best.status.Number > localBlockNumber || n.state.LatestBlockHash().IsEmpty() {
+		// Display found 1 new block if we sync the genesis block 0
+		newBlocksCount := best.status.Number - localBlockNumber
+		if localBlockNumber == 0 && best.status.Number == 0 {
+			newBlocksCount = 1
+		}
+		fmt.Printf("Found %d new blocks from peer %s\n", newBlocksCount, best.peer.TcpAddress())
+	} else {
+		fmt.Printf("Found a heavier chain at height %d from peer %s\n", best.status.Number, best.peer.TcpAddress())
+	}
+
+	headers, err := n.syncHeaders(best)
+	if err != nil {
+		return err
+	}
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	sources := chainPeers(best, ahead, headers)
+	peers := make([]PeerNode, len(sources))
+	for i, ps := range sources {
+		peers[i] = ps.peer
+	}
+
+	n.syncTracker.start(localBlockNumber, headers[len(headers)-1].Value.Number, len(headers), peers)
+	err = n.syncBodies(best.peer, sources, headers)
+	n.syncTracker.stop()
+
+	// The heads imported during the sync weren't announced, only the one it ended on is
+	n.announce(newHeadEvent(n.state.LatestBlockHash(), n.state.LatestBlock()))
+
+	return err
+}
+
+// Peers whose chain contains the headers fetched from the best peer, the blocks are only downloaded from those.
+// A peer on a competing branch doesn't have the blocks, it isn't misbehaving
+func chainPeers(best peerStatus, ahead []peerStatus, headers []database.BlockHeaderFS) []peerStatus {
+	isHeader := make(map[database.Hash]bool, len(headers))
+	for _, header := range headers {
+		isHeader[header.Key] = true
+	}
+
+	peers := []peerStatus{best}
+	for _, ps := range ahead {
+		if ps.peer.TcpAddress() != best.peer.TcpAddress() && isHeader[ps.status.Hash] {
+			peers = append(peers, ps)
+		}
+	}
+
+	return peers
+}
+
+func (n *Node) isPeerAhead(status StatusRes) bool {
 	// If the peer has no blocks, ignore it
 	if status.Hash.IsEmpty() {
-		return nil
+		return false
 	}
 
-	// If the peer has less blocks than us, ignore it
-	if status.Number < localBlockNumber {
-		return nil
+	if n.state.LatestBlockHash().IsEmpty() {
+		return true
 	}
 
-	// If it's the genesis block and we already synced it, ignore it
-	if status.Number == 0 && !n.state.LatestBlockHash().IsEmpty() {
-		return nil
+	// The heaviest chain wins, a peer on the same head or a branch not heavier than ours is ignored
+	return status.TotalDifficulty > n.state.TotalDifficulty()
+}
+
+// Returns the verified headers of the blocks missing locally, resuming an interrupted sync if possible.
+// The peer continues after the last resumed header or, on a competing branch, after the fork point
+// it finds in our block locator
+func (n *Node) syncHeaders(ps peerStatus) ([]database.BlockHeaderFS, error) {
+	progress, err := n.state.LoadSyncProgress()
+	if err != nil {
+		return nil, err
 	}
 
-	// Display found 1 new block if we sync the genesis block 0
-	newBlocksCount := status.Number - localBlockNumber
-	if localBlockNumber == 0 && status.Number == 0 {
-		newBlocksCount = 1
+	verifier := n.state.NewHeaderVerifier()
+	locator := n.state.BlockLocator()
+
+	resumed := n.unknownHeaders(progress.Headers)
+	if len(resumed) > 0 && n.state.HasBlock(resumed[0].Value.Parent) && verifier.Verify(resumed[0].Value.Parent, resumed) == nil {
+		locator = append([]database.Hash{resumed[len(resumed)-1].Key}, locator...)
+	} else {
+		resumed = nil
 	}
-	fmt.Printf("Found %d new blocks from peer %s\n", newBlocksCount, peer.TcpAddress())
 
-	blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
+	headers, err := n.fetchHeaderChain(ps, locator, verifier)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if len(resumed) > 0 && (len(headers) == 0 || headers[0].Value.Parent == resumed[len(resumed)-1].Key) {
+		fmt.Printf("Resuming the sync of %d blocks\n", len(resumed))
+		headers = append(resumed, headers...)
+	}
+
+	headers = n.unknownHeaders(headers)
+
+	return headers, n.state.SaveSyncProgress(database.SyncProgress{Headers: headers})
+}
+
+// Skips the leading headers of blocks already imported
+func (n *Node) unknownHeaders(headers []database.BlockHeaderFS) []database.BlockHeaderFS {
+	for i, header := range headers {
+		if !n.state.HasBlock(header.Key) {
+			return headers[i:]
+		}
+	}
+
+	return nil
+}
+
+// Downloads the blocks of the headers in rounds of parallel batches, each batch from another peer.
+// The sync cursor moves to the last header of every imported batch. The blocks match the headers served by
+// the best peer, the best peer is penalized when they turn out invalid
+func (n *Node) syncBodies(best PeerNode, peers []peerStatus, headers []database.BlockHeaderFS) error {
+	for len(headers) > 0 {
+		round := make([][]database.BlockHeaderFS, 0, syncMaxParallelFetches)
+		for i := 0; i < syncMaxParallelFetches && i*syncBlocksBatchSize < len(headers); i++ {
+			end := (i + 1) * syncBlocksBatchSize
+			if end > len(headers) {
+				end = len(headers)
+			}
+
+			round = append(round, headers[i*syncBlocksBatchSize:end])
+		}
+
+		batches := make([][]database.Block, len(round))
+		errs := make([]error, len(round))
+
+		var wg sync.WaitGroup
+		for i, batchHeaders := range round {
+			wg.Add(1)
+			go func(i int, batchHeaders []database.BlockHeaderFS) {
+				defer wg.Done()
+				batches[i], errs[i] = fetchBlockBatch(peers, i, batchHeaders)
+			}(i, batchHeaders)
+		}
+		wg.Wait()
+
+		for i, blocks := range batches {
+			if errs[i] != nil {
+				return errs[i]
+			}
+
+			err := n.importSyncedBlocks(blocks)
+			if err != nil {
+				n.penalizePeer(best.TcpAddress(), peerPenaltyInvalidBlock, err)
+				return err
+			}
+
+			err = n.state.SaveSyncCursor(headers[len(blocks)-1].Key)
+			if err != nil {
+				return err
+			}
+
+			headers = headers[len(blocks):]
+
+			n.syncTracker.update(len(headers))
+		}
 	}
 
+	return nil
+}
+
+func (n *Node) importSyncedBlocks(blocks []database.Block) error {
 	for _, block := range blocks {
+		blockHash, err := block.Hash()
+		if err != nil {
+			return err
+		}
+
+		if n.state.HasBlock(blockHash) {
+			continue
+		}
+
 		err = n.addBlock(block)
 		if err != nil {
 			return err
 		}
 
-		n.newSyncedBlocks <- block
+		// Side branch blocks don't replace the block being mined on
+		if n.state.LatestBlockHash() == blockHash {
+			n.newSyncedBlocks <- block
+		}
 	}
 
 	return nil
@@ -114,7 +386,7 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 
 func (n *Node) syncKnownPeers(status StatusRes) error {
 	for _, statusPeer := range status.KnownPeers {
-		if !n.IsKnownPeer(statusPeer) {
+		if !n.IsKnownPeer(statusPeer) && !n.IsBannedPeer(statusPeer.TcpAddress()) {
 			fmt.Printf("Found new peer %s\n", statusPeer.TcpAddress())
 			n.AddPeer(statusPeer)
 		}
@@ -125,7 +397,7 @@ func (n *Node) syncKnownPeers(status StatusRes) error {
 
 func (n *Node) syncPendingTXs(peer PeerNode, txs []database.SignedTx) error {
 	for _, tx := range txs {
-		err := n.AddPendingTX(tx, peer)
+		err := n.addPeerTX(tx, peer)
 		if err != nil {
 			return err
 		}
@@ -164,7 +436,7 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 		return fmt.Errorf(addPeerRes.Error)
 	}
 
-	knownPeer := n.knownPeers[peer.TcpAddress()]
+	knownPeer, _ := n.KnownPeer(peer.TcpAddress())
 	knownPeer.connected = addPeerRes.Success
 
 	n.AddPeer(knownPeer)
@@ -192,16 +464,171 @@ func queryPeerStatus(peer PeerNode) (StatusRes, error) {
 	return statusRes, nil
 }
 
-func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Block, error) {
+// Downloads and verifies the peer's headers up to the head it reported, page by page.
+// The first page follows the locator block the peer finds on its chain, the next ones the previous page.
+// A peer serving invalid headers is penalized
+func (n *Node) fetchHeaderChain(ps peerStatus, locator []database.Hash, verifier *database.HeaderVerifier) ([]database.BlockHeaderFS, error) {
+	headers := make([]database.BlockHeaderFS, 0)
+	limit := uint64(syncHeadersBatchSize)
+
+	for {
+		query := fmt.Sprintf("%s=%s", endpointSyncQueryKeyLocator, joinBlockLocator(locator))
+		if len(locator) == 0 {
+			query = fmt.Sprintf("%s=%s", endpointSyncQueryKeyFromBlock, database.Hash{}.Hex())
+		}
+		if len(headers) > 0 {
+			query = fmt.Sprintf("%s=%s", endpointSyncQueryKeyFromBlock, headers[len(headers)-1].Key.Hex())
+		}
+
+		url := fmt.Sprintf(
+			"%s://%s%s?%s&%s=%d",
+			ps.peer.ApiProtocol(),
+			ps.peer.TcpAddress(),
+			endpointSyncHeaders,
+			query,
+			endpointSyncQueryKeyLimit,
+			limit,
+		)
+
+		res, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+
+		syncHeadersRes := SyncHeadersRes{}
+		err = readRes(res, &syncHeadersRes)
+		if err != nil {
+			return nil, err
+		}
+
+		// The peer may have mined past the head it reported in the meantime
+		page := syncHeadersRes.Headers
+		for i, header := range page {
+			if header.Value.Number > ps.status.Number {
+				page = page[:i]
+				break
+			}
+		}
+
+		if len(page) == 0 {
+			return headers, nil
+		}
+
+		err = verifyHeadersPage(locator, headers, page, limit, verifier)
+		if err != nil {
+			n.penalizePeer(ps.peer.TcpAddress(), peerPenaltyInvalidBlock, err)
+			return nil, err
+		}
+
+		headers = append(headers, page...)
+
+		last := headers[len(headers)-1].Value.Number
+		if uint64(len(page)) < limit || last == ps.status.Number {
+			return headers, nil
+		}
+
+		if ps.status.Number-last < limit {
+			limit = ps.status.Number - last
+		}
+	}
+}
+
+// The first page must follow a locator block, the next ones the previous page,
+// no page may be longer than requested
+func verifyHeadersPage(locator []database.Hash, headers []database.BlockHeaderFS, page []database.BlockHeaderFS, limit uint64, verifier *database.HeaderVerifier) error {
+	if uint64(len(page)) > limit {
+		return fmt.Errorf("expected at most %d headers, got %d", limit, len(page))
+	}
+
+	parent := page[0].Value.Parent
+	if len(headers) > 0 {
+		parent = headers[len(headers)-1].Key
+	} else if !parent.IsEmpty() && !isLocatorBlock(locator, parent) {
+		return fmt.Errorf("headers must follow a block of the locator not '%s'", parent.Hex())
+	}
+
+	return verifier.Verify(parent, page)
+}
+
+func isLocatorBlock(locator []database.Hash, hash database.Hash) bool {
+	for _, locatorHash := range locator {
+		if locatorHash == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinBlockLocator(locator []database.Hash) string {
+	hexHashes := make([]string, len(locator))
+	for i, hash := range locator {
+		hexHashes[i] = hash.Hex()
+	}
+
+	return strings.Join(hexHashes, ",")
+}
+
+// Downloads the blocks of the headers from the given peer, falling back to the other peers.
+// Peers whose chain ends before the headers are skipped, blocks not matching the headers
+// are treated as not available, e.g. the peer switched to another branch in the meantime
+func fetchBlockBatch(peers []peerStatus, first int, headers []database.BlockHeaderFS) ([]database.Block, error) {
+	err := fmt.Errorf("no peer has the blocks %d to %d", headers[0].Value.Number, headers[len(headers)-1].Value.Number)
+
+	for attempt := 0; attempt < len(peers); attempt++ {
+		ps := peers[(first+attempt)%len(peers)]
+		if ps.status.Number < headers[len(headers)-1].Value.Number {
+			continue
+		}
+
+		blocks, fetchErr := fetchBlocksFromPeer(ps.peer, headers[0].Value.Parent, uint64(len(headers)))
+		if fetchErr == nil {
+			fetchErr = matchHeaders(blocks, headers)
+		}
+
+		if fetchErr != nil {
+			fmt.Printf("Importing blocks from peer %s failed. %s\n", ps.peer.TcpAddress(), fetchErr)
+			err = fetchErr
+			continue
+		}
+
+		return blocks, nil
+	}
+
+	return nil, err
+}
+
+func matchHeaders(blocks []database.Block, headers []database.BlockHeaderFS) error {
+	if len(blocks) != len(headers) {
+		return fmt.Errorf("expected %d blocks, got %d", len(headers), len(blocks))
+	}
+
+	for i, block := range blocks {
+		blockHash, err := block.Hash()
+		if err != nil {
+			return err
+		}
+
+		if blockHash != headers[i].Key {
+			return fmt.Errorf("block %d must be '%s' not '%s'", block.Header.Number, headers[i].Key.Hex(), blockHash.Hex())
+		}
+	}
+
+	return nil
+}
+
+func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash, limit uint64) ([]database.Block, error) {
 	fmt.Printf("Importing blocks from Peer %s...\n", peer.TcpAddress())
 
 	url := fmt.Sprintf(
-		"%s://%s%s?%s=%s",
+		"%s://%s%s?%s=%s&%s=%d",
 		peer.ApiProtocol(),
 		peer.TcpAddress(),
 		endpointSync,
 		endpointSyncQueryKeyFromBlock,
 		fromBlock.Hex(),
+		endpointSyncQueryKeyLimit,
+		limit,
 	)
 
 	res, err := http.Get(url)
@@ -0,0 +1,296 @@
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestNode_SyncBlocks(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64},
+		Balances:    map[common.Address]uint{sender: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	peer, peerDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(peerDataDir)
+	defer peer.state.Close()
+
+	miner := database.NewAccount(DefaultMiner)
+	mineTestNodeBlocks(t, peer, miner, privKey, 5)
+
+	server, peerNode := serveTestNode(t, peer)
+	defer server.Close()
+	n.AddPeer(peerNode)
+
+	// The mining loop isn't running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go drainSyncedBlocks(ctx, n)
+
+	n.doSync()
+
+	if n.state.LatestBlockHash() != peer.state.LatestBlockHash() {
+		t.Fatalf("node must sync up to the peer's head, got block %d", n.state.LatestBlock().Header.Number)
+	}
+
+	progress, err := n.state.LoadSyncProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress.Headers) != 0 || n.syncTracker.status(0).Syncing {
+		t.Fatalf("finished sync must leave no headers to download")
+	}
+}
+
+func TestNode_SyncSkipsCompetingBranch(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mined one block per second, on target
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64, TargetBlockTime: 1},
+		Balances:    map[common.Address]uint{sender: 10000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	best, bestDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(bestDataDir)
+	defer best.state.Close()
+
+	competing, competingDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(competingDataDir)
+	defer competing.state.Close()
+
+	// Both share the first batch of blocks, the competing peer knows
+	// where the second batch starts but has other blocks after it
+	mineTestNodeBlocks(t, best, database.NewAccount(DefaultMiner), privKey, syncBlocksBatchSize+2)
+	for height := uint64(0); height < syncBlocksBatchSize+2; height++ {
+		block, err := best.state.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = competing.addBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mineTestNodeBlocks(t, best, database.NewAccount(DefaultMiner), privKey, 8)
+	mineTestNodeBlocks(t, competing, database.NewAccount(testKsAccount1), privKey, 5)
+
+	bestServer, bestPeer := serveTestNode(t, best)
+	defer bestServer.Close()
+	n.AddPeer(bestPeer)
+
+	competingServer, competingPeer := serveTestNode(t, competing)
+	defer competingServer.Close()
+	n.AddPeer(competingPeer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go drainSyncedBlocks(ctx, n)
+
+	n.doSync()
+
+	if n.state.LatestBlockHash() != best.state.LatestBlockHash() {
+		t.Fatalf("node must sync up to the best peer's head, got block %d", n.state.LatestBlock().Header.Number)
+	}
+
+	for _, record := range n.PeerRecords() {
+		if record.Stats.Score < 0 {
+			t.Fatalf("honest peer %s must not be penalized, got score %d", record.Peer.TcpAddress(), record.Stats.Score)
+		}
+	}
+}
+
+func TestNode_FetchHeaderChain(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64, TargetBlockTime: 1},
+		Balances:    map[common.Address]uint{sender: 10000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	peer, peerDataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(peerDataDir)
+	defer peer.state.Close()
+
+	// The node forks off the peer's chain after block 2
+	mineTestNodeBlocks(t, peer, database.NewAccount(DefaultMiner), privKey, 3)
+	for height := uint64(0); height < 3; height++ {
+		block, err := peer.state.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = n.addBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mineTestNodeBlocks(t, n, database.NewAccount(testKsAccount1), privKey, 1)
+	mineTestNodeBlocks(t, peer, database.NewAccount(DefaultMiner), privKey, 3)
+
+	server, peerNode := serveTestNode(t, peer)
+	defer server.Close()
+
+	status, err := queryPeerStatus(peerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer mined block 5 after reporting block 4 as its head
+	status.Number = 4
+	headers, err := n.fetchHeaderChain(peerStatus{peerNode, status}, n.state.BlockLocator(), n.state.NewHeaderVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forkPoint, err := n.state.GetBlockByHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forkHash, err := forkPoint.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 2 || headers[0].Value.Parent != forkHash || headers[1].Value.Number != 4 {
+		t.Fatalf("headers must follow the fork point up to the reported head, got %d headers", len(headers))
+	}
+}
+
+func TestNode_PeerAheadByTotalDifficulty(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := database.Genesis{
+		ChainParams: database.ChainParams{Difficulty: 64},
+		Balances:    map[common.Address]uint{sender: 1000},
+	}
+
+	n, dataDir := newTestNodeWithGenesis(t, genesis)
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	mineTestNodeBlocks(t, n, database.NewAccount(DefaultMiner), privKey, 3)
+	localNumber := n.state.LatestBlock().Header.Number
+	localTotalDifficulty := n.state.TotalDifficulty()
+
+	longer := StatusRes{Hash: database.Hash{1}, Number: localNumber + 5, TotalDifficulty: localTotalDifficulty}
+	if n.isPeerAhead(longer) {
+		t.Fatalf("longer chain not heavier than ours must be ignored")
+	}
+
+	heavier := StatusRes{Hash: database.Hash{2}, Number: localNumber - 1, TotalDifficulty: localTotalDifficulty + 1}
+	if !n.isPeerAhead(heavier) {
+		t.Fatalf("shorter chain heavier than ours must be synced")
+	}
+}
+
+// Mines the blocks on top of the node's head, each with a TX of the sender
+func mineTestNodeBlocks(t *testing.T, n *Node, miner common.Address, privKey *ecdsa.PrivateKey, count int) {
+	sender := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	for i := 0; i < count; i++ {
+		tx, err := wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 10, n.state.GetNextAccountNonce(sender), ""), privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pb, err := NewPendingBlockFromState(n.state, miner, []database.SignedTx{tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := Mine(context.Background(), pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = n.addBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Serves the node's sync endpoints, returns the server and the node as a peer to sync from
+func serveTestNode(t *testing.T, n *Node) (*httptest.Server, PeerNode) {
+	handler := http.NewServeMux()
+	handler.HandleFunc(endpointStatus, func(w http.ResponseWriter, r *http.Request) {
+		statusHandler(w, r, n)
+	})
+	handler.HandleFunc(endpointSync, func(w http.ResponseWriter, r *http.Request) {
+		syncHandler(w, r, n)
+	})
+	handler.HandleFunc(endpointSyncHeaders, func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, n)
+	})
+	handler.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, r *http.Request) {
+		addPeerHandler(w, r, n)
+	})
+
+	server := httptest.NewServer(handler)
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.ParseUint(serverUrl.Port(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, NewPeerNode(serverUrl.Hostname(), port, false, n.info.Account, true)
+}
+
+// Stands in for the mining loop which isn't running
+func drainSyncedBlocks(ctx context.Context, n *Node) {
+	for {
+		select {
+		case <-n.newSyncedBlocks:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const DefaultTxQueueMaxSize = 1024
+const DefaultTxQueueMaxPerSender = 16
+const DefaultTxQueueMaxNonceGap = 16
+
+// Future nonce TXs waiting for the sender's missing TXs before becoming pending.
+// Gossiped TXs often arrive out of order, the nonce gap limits how far ahead a TX can be
+type TxQueue struct {
+	mu           sync.RWMutex
+	maxSize      int
+	maxPerSender int
+	maxNonceGap  uint
+	ttl          time.Duration
+	size         int
+	accounts     map[common.Address]map[uint]MempoolTx
+}
+
+func NewTxQueue(maxSize int, maxPerSender int, maxNonceGap uint, ttl time.Duration) *TxQueue {
+	return &TxQueue{
+		maxSize:      maxSize,
+		maxPerSender: maxPerSender,
+		maxNonceGap:  maxNonceGap,
+		ttl:          ttl,
+		accounts:     make(map[common.Address]map[uint]MempoolTx),
+	}
+}
+
+// Queues the TX ahead of the sender's next nonce. A TX queued with the same nonce is kept
+func (q *TxQueue) Add(tx MempoolTx, nextNonce uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if tx.Tx.Nonce <= nextNonce {
+		return fmt.Errorf("TX nonce '%d' isn't ahead of the sender's next nonce '%d'", tx.Tx.Nonce, nextNonce)
+	}
+
+	if tx.Tx.Nonce-nextNonce > q.maxNonceGap {
+		return fmt.Errorf("TX nonce '%d' is more than %d ahead of the sender's next nonce '%d'", tx.Tx.Nonce, q.maxNonceGap, nextNonce)
+	}
+
+	queued := q.accounts[tx.Tx.From]
+	if _, isQueued := queued[tx.Tx.Nonce]; isQueued {
+		return fmt.Errorf("sender '%s' already has a queued TX with nonce '%d'", tx.Tx.From.String(), tx.Tx.Nonce)
+	}
+
+	if len(queued) >= q.maxPerSender {
+		return fmt.Errorf("sender '%s' already has %d queued TXs", tx.Tx.From.String(), q.maxPerSender)
+	}
+
+	if q.size >= q.maxSize {
+		return fmt.Errorf("TX queue is full")
+	}
+
+	if queued == nil {
+		queued = make(map[uint]MempoolTx)
+		q.accounts[tx.Tx.From] = queued
+	}
+
+	queued[tx.Tx.Nonce] = tx
+	q.size++
+
+	return nil
+}
+
+// Removes and returns the sender's TX with the given nonce, ready to become pending
+func (q *TxQueue) Pop(account common.Address, nonce uint) (MempoolTx, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tx, isQueued := q.accounts[account][nonce]
+	if isQueued {
+		q.remove(account, nonce)
+	}
+
+	return tx, isQueued
+}
+
+// Drops the sender's TXs with a nonce already taken, e.g. mined from another node's Mempool
+func (q *TxQueue) Prune(account common.Address, nextNonce uint) []database.Hash {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pruned := make([]database.Hash, 0)
+	for nonce, tx := range q.accounts[account] {
+		if nonce < nextNonce {
+			pruned = append(pruned, tx.Hash)
+			q.remove(account, nonce)
+		}
+	}
+
+	return pruned
+}
+
+// Removes the TXs queued for longer than the TTL and returns their hashes
+func (q *TxQueue) Expire(now time.Time) []database.Hash {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	expired := make([]database.Hash, 0)
+	for account, queued := range q.accounts {
+		for nonce, tx := range queued {
+			if now.Sub(tx.AddedAt) > q.ttl {
+				expired = append(expired, tx.Hash)
+				q.remove(account, nonce)
+			}
+		}
+	}
+
+	return expired
+}
+
+func (q *TxQueue) remove(account common.Address, nonce uint) {
+	delete(q.accounts[account], nonce)
+	q.size--
+
+	if len(q.accounts[account]) == 0 {
+		delete(q.accounts, account)
+	}
+}
+
+func (q *TxQueue) Get(hash database.Hash) (database.SignedTx, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, queued := range q.accounts {
+		for _, tx := range queued {
+			if tx.Hash == hash {
+				return tx.Tx, true
+			}
+		}
+	}
+
+	return database.SignedTx{}, false
+}
+
+func (q *TxQueue) Has(hash database.Hash) bool {
+	_, isQueued := q.Get(hash)
+	return isQueued
+}
+
+func (q *TxQueue) Len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.size
+}
+
+func (q *TxQueue) Accounts() []common.Address {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	accounts := make([]common.Address, 0, len(q.accounts))
+	for account := range q.accounts {
+		accounts = append(accounts, account)
+	}
+
+	return accounts
+}
+
+// Lists the queued TXs by sender and nonce
+func (q *TxQueue) TXs() []MempoolTx {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	txs := make([]MempoolTx, 0, q.size)
+	for _, queued := range q.accounts {
+		for _, tx := range queued {
+			txs = append(txs, tx)
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].Tx.From != txs[j].Tx.From {
+			return txs[i].Tx.From.Hex() < txs[j].Tx.From.Hex()
+		}
+
+		return txs[i].Tx.Nonce < txs[j].Tx.Nonce
+	})
+
+	return txs
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethanblumenthal/golang-blockchain/fs"
+	"github.com/ethanblumenthal/golang-blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNode_QueuedTXs(t *testing.T) {
+	privKey, _, sender, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, dataDir := newTestNodeWithState(t, map[common.Address]uint{sender: 1000})
+	defer fs.RemoveDir(dataDir)
+	defer n.state.Close()
+
+	signedTXs := make(map[uint]database.SignedTx)
+	for _, nonce := range []uint{1, 2, 3, 1 + DefaultTxQueueMaxNonceGap + 1} {
+		signedTXs[nonce], err = wallet.SignTx(database.NewTx(sender, database.NewAccount(testKsAccount1), 1, nonce, ""), privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, nonce := range []uint{3, 2} {
+		err = n.AddPendingTX(signedTXs[nonce], n.info)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n.mempool.Len() != 0 || n.txQueue.Len() != 2 {
+		t.Fatalf("TXs ahead of the sender's next nonce must be queued, got %d pending and %d queued", n.mempool.Len(), n.txQueue.Len())
+	}
+
+	err = n.AddPendingTX(signedTXs[1+DefaultTxQueueMaxNonceGap+1], n.info)
+	if err == nil {
+		t.Fatalf("TX further ahead than the max nonce gap must be rejected")
+	}
+
+	err = n.AddPendingTX(signedTXs[1], n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.mempool.Len() != 3 || n.txQueue.Len() != 0 {
+		t.Fatalf("queued TXs must become pending once the nonce gap fills, got %d pending and %d queued", n.mempool.Len(), n.txQueue.Len())
+	}
+
+	if n.pendingState.GetNextAccountNonce(sender) != 4 {
+		t.Fatalf("pending state must include the promoted TXs")
+	}
+}
@@ -0,0 +1,212 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+const endpointWS = "/ws"
+
+const WsActionSubscribe = "subscribe"
+const WsActionUnsubscribe = "unsubscribe"
+
+const WsTopicNewHeads = "new_heads"
+const WsTopicPendingTXs = "pending_txs"
+const WsTopicAddress = "address"
+
+// Largest subscription message a client may send
+const wsMaxMessageSize = 1024
+
+var wsUpgrader = websocket.Upgrader{
+	// The HTTP API is public, see enableCors
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type WsReq struct {
+	Action  string `json:"action"`
+	Topic   string `json:"topic"`
+	Address string `json:"address,omitempty"`
+}
+
+type WsMsg struct {
+	Topic   string          `json:"topic,omitempty"`
+	Address *common.Address `json:"address,omitempty"`
+	Data    interface{}     `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type WsNewHead struct {
+	Hash   database.Hash        `json:"hash"`
+	Header database.BlockHeader `json:"header"`
+}
+
+// A TX sent or received by a subscribed address
+type WsAddressActivity struct {
+	Status      string            `json:"status"`
+	Hash        database.Hash     `json:"hash"`
+	Tx          database.SignedTx `json:"tx"`
+	BlockHash   database.Hash     `json:"block_hash"`
+	BlockNumber uint64            `json:"block_number"`
+}
+
+// Topics a single WebSocket client is subscribed to
+type wsSubscriptions struct {
+	mu         sync.Mutex
+	newHeads   bool
+	pendingTXs bool
+	addresses  map[common.Address]bool
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(wsMaxMessageSize)
+
+	subID, events := node.events.Subscribe()
+	defer node.events.Unsubscribe(subID)
+
+	subs := &wsSubscriptions{addresses: make(map[common.Address]bool)}
+	replies := make(chan WsMsg, eventSubscriberBuffer)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+
+	// Only this goroutine reads, replies are written by the loop below
+	// as a connection supports a single concurrent writer
+	go func() {
+		defer close(closed)
+
+		for {
+			var req WsReq
+			err := conn.ReadJSON(&req)
+			if err != nil {
+				return
+			}
+
+			select {
+			case replies <- subs.update(req):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case reply := <-replies:
+			err = conn.WriteJSON(reply)
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+
+			for _, msg := range subs.match(e) {
+				err = conn.WriteJSON(msg)
+				if err != nil {
+					break
+				}
+			}
+		case <-closed:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Applies the subscription request and returns its acknowledgement
+func (s *wsSubscriptions) update(req WsReq) WsMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isSubscribed := req.Action == WsActionSubscribe
+	if !isSubscribed && req.Action != WsActionUnsubscribe {
+		return WsMsg{Error: fmt.Sprintf("unknown action '%s', use '%s' or '%s'", req.Action, WsActionSubscribe, WsActionUnsubscribe)}
+	}
+
+	switch req.Topic {
+	case WsTopicNewHeads:
+		s.newHeads = isSubscribed
+	case WsTopicPendingTXs:
+		s.pendingTXs = isSubscribed
+	case WsTopicAddress:
+		if !common.IsHexAddress(req.Address) {
+			return WsMsg{Topic: req.Topic, Error: fmt.Sprintf("'%s' is an invalid account address", req.Address)}
+		}
+
+		account := database.NewAccount(req.Address)
+		if isSubscribed {
+			s.addresses[account] = true
+		} else {
+			delete(s.addresses, account)
+		}
+
+		return WsMsg{Topic: req.Topic, Address: &account, Data: req.Action}
+	default:
+		return WsMsg{Topic: req.Topic, Error: fmt.Sprintf("unknown topic '%s'", req.Topic)}
+	}
+
+	return WsMsg{Topic: req.Topic, Data: req.Action}
+}
+
+// Converts the event into the messages the client is subscribed to
+func (s *wsSubscriptions) match(e Event) []WsMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := make([]WsMsg, 0)
+
+	switch e.Type {
+	case EventNewHead:
+		if s.newHeads {
+			msgs = append(msgs, WsMsg{Topic: WsTopicNewHeads, Data: WsNewHead{e.Hash, e.Block.Header}})
+		}
+
+		for _, tx := range e.Block.TXs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				continue
+			}
+
+			activity := WsAddressActivity{TxStatusMined, txHash, tx, e.Hash, e.Block.Header.Number}
+			msgs = append(msgs, s.matchAddresses(tx, activity)...)
+		}
+	case EventPendingTx:
+		if s.pendingTXs {
+			msgs = append(msgs, WsMsg{Topic: WsTopicPendingTXs, Data: BlockTx{e.Hash, *e.Tx}})
+		}
+
+		msgs = append(msgs, s.matchAddresses(*e.Tx, WsAddressActivity{Status: TxStatusPending, Hash: e.Hash, Tx: *e.Tx})...)
+	}
+
+	return msgs
+}
+
+func (s *wsSubscriptions) matchAddresses(tx database.SignedTx, activity WsAddressActivity) []WsMsg {
+	msgs := make([]WsMsg, 0)
+
+	for _, account := range []common.Address{tx.From, tx.To} {
+		if s.addresses[account] {
+			account := account
+			msgs = append(msgs, WsMsg{Topic: WsTopicAddress, Address: &account, Data: activity})
+		}
+
+		if tx.From == tx.To {
+			break
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethanblumenthal/golang-blockchain/database"
+	"github.com/gorilla/websocket"
+)
+
+func TestNode_WsSubscriptions(t *testing.T) {
+	n := New(t.TempDir(), "127.0.0.1", 8085, database.NewAccount(DefaultMiner), PeerNode{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(w, r, n)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	account := database.NewAccount(testKsAccount1)
+	for _, req := range []WsReq{{WsActionSubscribe, WsTopicNewHeads, ""}, {WsActionSubscribe, WsTopicAddress, account.String()}} {
+		err = conn.WriteJSON(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ack WsMsg
+		err = conn.ReadJSON(&ack)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ack.Error != "" || ack.Topic != req.Topic {
+			t.Fatalf("subscription to '%s' must be acknowledged, got %+v", req.Topic, ack)
+		}
+	}
+
+	// Not subscribed to pending TXs, only the address activity is received
+	tx := database.NewSignedTx(database.NewTx(database.NewAccount(testKsAccount2), account, 10, 1, ""), nil)
+	n.events.Publish(newPendingTxEvent(database.Hash{1}, tx))
+	n.events.Publish(newHeadEvent(database.Hash{2}, database.Block{Header: database.BlockHeader{Number: 7}}))
+
+	var activity struct {
+		Topic string            `json:"topic"`
+		Data  WsAddressActivity `json:"data"`
+	}
+	err = conn.ReadJSON(&activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if activity.Topic != WsTopicAddress || activity.Data.Status != TxStatusPending || activity.Data.Hash != (database.Hash{1}) {
+		t.Fatalf("pending TX received by '%s' must be notified, got %+v", account.String(), activity)
+	}
+
+	var head struct {
+		Topic string    `json:"topic"`
+		Data  WsNewHead `json:"data"`
+	}
+	err = conn.ReadJSON(&head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if head.Topic != WsTopicNewHeads || head.Data.Hash != (database.Hash{2}) || head.Data.Header.Number != 7 {
+		t.Fatalf("new head must be notified, got %+v", head)
+	}
+}